@@ -0,0 +1,35 @@
+// Package authtest provides a local stand-in for the SSO API identity
+// provider, so that token validation and sign in can be tested without
+// reaching sso-api.*.users.enlight.skf.com.
+//
+// The Server serves its RSA keys in the JWKS format understood by the jwk
+// package, mints access and ID tokens signed with those keys and implements
+// the sign in endpoints used by the auth and cachedauth packages.
+//
+// # Examples
+//
+// Validating tokens in a handler test
+//
+//	func Test_Handler(t *testing.T) {
+//	    idp := authtest.NewServer(t)
+//	    jwk.KeySetURL = idp.KeySetsURL()
+//
+//	    token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "..."})
+//
+//	    req := httptest.NewRequest(http.MethodGet, "/me", nil)
+//	    req.Header.Set("Authorization", token)
+//	    ...
+//	}
+//
+// Signing in
+//
+//	func Test_SignIn(t *testing.T) {
+//	    idp := authtest.NewServer(t)
+//	    idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+//
+//	    client, err := auth.NewClient(auth.ClientConfig{BaseURL: idp.URL})
+//	    ...
+//	    tokens, err := client.SignIn(ctx, "a.b@example.com", "secret")
+//	    ...
+//	}
+package authtest
@@ -0,0 +1,440 @@
+package authtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+
+	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/jwk"
+	"github.com/SKF/go-utility/v2/jwt"
+	"github.com/SKF/go-utility/v2/uuid"
+)
+
+// KeySetsFormat is the member of the JWKS response the keys are served in.
+type KeySetsFormat string
+
+const (
+	KeySetsFormatCognito KeySetsFormat = "Keys"
+	KeySetsFormatRFC7517 KeySetsFormat = "keys"
+	KeySetsFormatSSOAPI  KeySetsFormat = "data"
+)
+
+const (
+	userIDPrefix   = "enlightUserId:"
+	authorIDPrefix = "authorId:"
+
+	keySize = 2048
+)
+
+// User is a user of the Server. The fields are used both to sign in and as
+// claims of the minted tokens.
+type User struct {
+	Username string
+	Password string
+	// Subject defaults to Username.
+	Subject string
+	// UserID is the Enlight User ID of the user the token is for.
+	UserID string
+	// AuthorID is the Enlight User ID of the user who created the token.
+	// It differs from UserID when impersonating and defaults to UserID.
+	AuthorID  string
+	CompanyID string
+	Email     string
+	Roles     string
+	Access    string
+	// Groups are added to the cognito groups after the user and author ID
+	// groups.
+	Groups []string
+	// Scopes are the OAuth scopes of access tokens.
+	Scopes []string
+	// NewPasswordRequired makes sign in return a NEW_PASSWORD_REQUIRED
+	// challenge, which has to be completed before tokens are issued.
+	NewPasswordRequired bool
+	// MFACode makes sign in return a SOFTWARE_TOKEN_MFA challenge, which
+	// is completed with the code in the "code" property.
+	MFACode string
+}
+
+// Server is a stand-in for the SSO API. It is closed when the test finishes.
+type Server struct {
+	// URL is the base URL of the server, usable as auth.Config.BaseURL.
+	// It is also the issuer of the minted tokens.
+	URL string
+	// TokenLifetime is the time until minted tokens expire.
+	TokenLifetime time.Duration
+
+	tb     testing.TB
+	server *httptest.Server
+
+	lock          sync.Mutex
+	keys          []signingKey
+	format        KeySetsFormat
+	users         map[string]User
+	challenges    map[string]string
+	refreshTokens map[string]string
+}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// NewServer starts a Server with a newly generated signing key serving its
+// keys in the RFC 7517 format.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+
+	s := &Server{
+		TokenLifetime: time.Hour,
+		tb:            tb,
+		format:        KeySetsFormatRFC7517,
+		users:         map[string]User{},
+		challenges:    map[string]string{},
+		refreshTokens: map[string]string{},
+	}
+
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jwks", s.handleKeySets)
+	mux.HandleFunc("POST /sign-in/initiate", s.handleInitiateSignIn)
+	mux.HandleFunc("POST /sign-in/complete", s.handleCompleteSignIn)
+
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+
+	tb.Cleanup(s.Close)
+
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client configured for the server.
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// KeySetsURL is the URL the keys are served on, usable as jwk.KeySetURL.
+func (s *Server) KeySetsURL() string {
+	return s.URL + "/jwks"
+}
+
+// SetKeySetsFormat changes the member of the JWKS response the keys are
+// served in.
+func (s *Server) SetKeySetsFormat(format KeySetsFormat) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.format = format
+}
+
+// RotateKey generates a new signing key. Previous keys are still served, so
+// tokens signed with them remain valid.
+func (s *Server) RotateKey() {
+	s.tb.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		s.tb.Fatalf("failed to generate signing key: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys = append(s.keys, signingKey{id: uuid.New().String(), key: key})
+}
+
+// AddUser adds a user that can sign in, replacing any user with the same
+// username.
+func (s *Server) AddUser(user User) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.users[user.Username] = user
+}
+
+// ImpersonationGroups returns the cognito groups carrying the user and author
+// IDs of a token.
+func ImpersonationGroups(userID, authorID string) []string {
+	var groups []string
+
+	if userID != "" {
+		groups = append(groups, userIDPrefix+userID)
+	}
+
+	if authorID != "" {
+		groups = append(groups, authorIDPrefix+authorID)
+	}
+
+	return groups
+}
+
+// Claims returns the claims of a token for the user, which can be modified
+// before being signed with Sign.
+func (s *Server) Claims(user User, tokenUse string) jwt.Claims {
+	now := time.Now()
+
+	subject := user.Subject
+	if subject == "" {
+		subject = user.Username
+	}
+
+	authorID := user.AuthorID
+	if authorID == "" {
+		authorID = user.UserID
+	}
+
+	var scope string
+	if tokenUse == jwt.TokenUseAccess {
+		scope = strings.Join(user.Scopes, " ")
+	}
+
+	return jwt.Claims{
+		RegisteredClaims: gojwt.RegisteredClaims{
+			Issuer:    s.URL,
+			Subject:   subject,
+			IssuedAt:  gojwt.NewNumericDate(now),
+			ExpiresAt: gojwt.NewNumericDate(now.Add(s.TokenLifetime)),
+			ID:        uuid.New().String(),
+		},
+		CognitoClaims: jwt.CognitoClaims{
+			Username:      user.Username,
+			TokenUse:      tokenUse,
+			CognitoGroups: append(ImpersonationGroups(user.UserID, authorID), user.Groups...),
+			Scope:         scope,
+		},
+		EnlightClaims: jwt.EnlightClaims{
+			EnlightUserID:    user.UserID,
+			EnlightCompanyID: user.CompanyID,
+			EnlightAccess:    user.Access,
+			EnlightRoles:     user.Roles,
+			EnlightEmail:     user.Email,
+		},
+	}
+}
+
+// Sign signs the claims with the current signing key.
+func (s *Server) Sign(claims jwt.Claims) string {
+	s.tb.Helper()
+
+	signed, err := s.sign(claims)
+	if err != nil {
+		s.tb.Fatalf("failed to sign token: %v", err)
+	}
+
+	return signed
+}
+
+// AccessToken mints an access token for the user.
+func (s *Server) AccessToken(user User) string {
+	s.tb.Helper()
+	return s.Sign(s.Claims(user, jwt.TokenUseAccess))
+}
+
+// IDToken mints an ID token for the user.
+func (s *Server) IDToken(user User) string {
+	s.tb.Helper()
+	return s.Sign(s.Claims(user, jwt.TokenUseID))
+}
+
+func (s *Server) sign(claims jwt.Claims) (string, error) {
+	s.lock.Lock()
+	current := s.keys[len(s.keys)-1]
+	s.lock.Unlock()
+
+	token := gojwt.NewWithClaims(gojwt.SigningMethodRS256, claims)
+	token.Header["kid"] = current.id
+
+	return token.SignedString(current.key)
+}
+
+func (s *Server) tokens(user User) (auth.Tokens, error) {
+	accessToken, err := s.sign(s.Claims(user, jwt.TokenUseAccess))
+	if err != nil {
+		return auth.Tokens{}, err
+	}
+
+	identityToken, err := s.sign(s.Claims(user, jwt.TokenUseID))
+	if err != nil {
+		return auth.Tokens{}, err
+	}
+
+	refreshToken := uuid.New().String()
+
+	s.lock.Lock()
+	s.refreshTokens[refreshToken] = user.Username
+	s.lock.Unlock()
+
+	return auth.Tokens{
+		AccessToken:   accessToken,
+		IdentityToken: identityToken,
+		RefreshToken:  refreshToken,
+	}, nil
+}
+
+func (s *Server) handleKeySets(w http.ResponseWriter, _ *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keySets := make(jwk.JWKeySets, 0, len(s.keys))
+	for _, k := range s.keys {
+		keySets = append(keySets, jwk.JWKeySet{
+			Algorithm: gojwt.SigningMethodRS256.Alg(),
+			Exp:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+			KeyID:     k.id,
+			KeyType:   jwk.KeyTypeRSA,
+			Mod:       base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			Use:       "sig",
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]jwk.JWKeySets{string(s.format): keySets})
+}
+
+func (s *Server) handleInitiateSignIn(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.lock.Lock()
+
+	username := body.Username
+	if body.RefreshToken != "" {
+		username = s.refreshTokens[body.RefreshToken]
+	}
+
+	user, found := s.users[username]
+
+	s.lock.Unlock()
+
+	switch {
+	case !found:
+		writeError(w, http.StatusUnauthorized, "incorrect username or password")
+		return
+	case body.RefreshToken == "" && user.Password != body.Password:
+		writeError(w, http.StatusUnauthorized, "incorrect username or password")
+		return
+	case body.RefreshToken == "" && user.NewPasswordRequired:
+		s.writeChallenge(w, user, auth.ChallengeNewPasswordRequired)
+		return
+	case body.RefreshToken == "" && user.MFACode != "":
+		s.writeChallenge(w, user, auth.ChallengeSoftwareTokenMFA)
+		return
+	}
+
+	s.writeTokens(w, user)
+}
+
+func (s *Server) handleCompleteSignIn(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username   string            `json:"username"`
+		ID         string            `json:"id"`
+		Type       string            `json:"type"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	s.lock.Lock()
+
+	user, found := s.users[body.Username]
+	valid := found && s.challenges[body.ID] == body.Username
+
+	switch {
+	case !valid:
+	case body.Type == auth.ChallengeNewPasswordRequired && user.NewPasswordRequired:
+		user.Password = body.Properties["newPassword"]
+		user.NewPasswordRequired = false
+		s.users[user.Username] = user
+	case body.Type == auth.ChallengeSoftwareTokenMFA && user.MFACode != "":
+		valid = body.Properties["code"] == user.MFACode
+	default:
+		valid = false
+	}
+
+	delete(s.challenges, body.ID)
+
+	s.lock.Unlock()
+
+	if !valid {
+		writeError(w, http.StatusUnauthorized, "invalid challenge")
+		return
+	}
+
+	if body.Type == auth.ChallengeNewPasswordRequired && user.MFACode != "" {
+		s.writeChallenge(w, user, auth.ChallengeSoftwareTokenMFA)
+		return
+	}
+
+	s.writeTokens(w, user)
+}
+
+func (s *Server) writeChallenge(w http.ResponseWriter, user User, challengeType string) {
+	challenge := auth.Challenge{ID: uuid.New().String(), Type: challengeType}
+
+	s.lock.Lock()
+	s.challenges[challenge.ID] = user.Username
+	s.lock.Unlock()
+
+	var resp auth.SignInResponse
+	resp.Data.Challenge = challenge
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) writeTokens(w http.ResponseWriter, user User) {
+	tokens, err := s.tokens(user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to mint tokens: %v", err))
+		return
+	}
+
+	var resp auth.SignInResponse
+	resp.Data.Tokens = tokens
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	resp.Error.Message = message
+
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
@@ -0,0 +1,145 @@
+package authtest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/auth/cachedauth"
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	"github.com/SKF/go-utility/v2/impersonatercontext"
+	"github.com/SKF/go-utility/v2/jwk"
+	"github.com/SKF/go-utility/v2/jwt"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+// These tests configure global variables in the auth and jwk packages and
+// can't be run in parallel.
+
+func Test_KeySetsFormats(t *testing.T) {
+	for _, format := range []authtest.KeySetsFormat{
+		authtest.KeySetsFormatCognito,
+		authtest.KeySetsFormatRFC7517,
+		authtest.KeySetsFormatSSOAPI,
+	} {
+		t.Run(string(format), func(t *testing.T) {
+			idp := authtest.NewServer(t)
+			idp.SetKeySetsFormat(format)
+
+			jwk.KeySetURL = idp.KeySetsURL()
+
+			token, err := jwt.Parse(idp.AccessToken(authtest.User{Username: "a.b@example.com"}))
+			require.NoError(t, err)
+			assert.Equal(t, "a.b@example.com", token.GetClaims().Username)
+		})
+	}
+}
+
+func Test_RotatedKeysRemainValid(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	user := authtest.User{UserID: "user", Username: "a.b@example.com"}
+	before := idp.IDToken(user)
+
+	idp.RotateKey()
+	after := idp.IDToken(user)
+
+	for _, token := range []string{before, after} {
+		parsed, err := jwt.Parse(token, jwt.WithKeyProvider(provider))
+		require.NoError(t, err)
+		assert.Equal(t, "user", parsed.GetClaims().EnlightUserID)
+	}
+}
+
+func Test_SignIn(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret", UserID: "user"})
+
+	auth.Configure(auth.Config{BaseURL: idp.URL})
+
+	tokens, err := auth.SignIn(context.Background(), "a.b@example.com", "wrong")
+	require.Error(t, err)
+	assert.Empty(t, tokens.AccessToken)
+
+	tokens, err = auth.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.NoError(t, err)
+
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	parsed, err := jwt.Parse(tokens.IdentityToken, jwt.WithKeyProvider(provider))
+	require.NoError(t, err)
+	assert.Equal(t, "user", parsed.GetClaims().EnlightUserID)
+
+	refreshed, err := auth.SignInRefreshToken(context.Background(), tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, refreshed.AccessToken)
+}
+
+func Test_SignInNewPasswordRequired(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret", NewPasswordRequired: true})
+
+	auth.Configure(auth.Config{BaseURL: idp.URL})
+
+	tokens, err := auth.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+}
+
+func Test_CachedAuthSignIn(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	cachedauth.Configure(cachedauth.Config{BaseURL: idp.URL})
+
+	require.NoError(t, cachedauth.SignIn(context.Background(), "a.b@example.com", "secret"))
+
+	first := cachedauth.GetTokens()
+	assert.NotEmpty(t, first.AccessToken)
+
+	require.NoError(t, cachedauth.SignIn(context.Background(), "a.b@example.com", "secret"))
+	assert.Equal(t, first, cachedauth.GetTokens())
+}
+
+func Test_AuthenticateMiddlewareImpersonation(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := useridcontext.FromContext(r.Context())
+		authorID, _ := impersonatercontext.FromContext(r.Context())
+		w.Write([]byte(userID + " " + authorID)) //nolint:errcheck
+	})
+	r.Use(httpmiddleware.AuthenticateMiddlewareV3(httpmiddleware.WithKeyProvider(provider)))
+
+	httpmiddleware.HandleSecureEndpoint("/me").Methods(http.MethodGet).AccessToken()
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(httpmiddleware.HeaderAuthorization, idp.AccessToken(authtest.User{
+		Username: "admin@example.com",
+		UserID:   "impersonated",
+		AuthorID: "admin",
+	}))
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "impersonated admin", resp.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/SKF/go-utility/v2/auth"
 )
@@ -22,6 +21,7 @@ type Config struct {
 	WithOpenCensusTracing bool   // default
 	ServiceName           string // needed when using lambda and Datadog for tracing
 	Stage                 string
+	BaseURL               string // overrides the URL derived from Stage
 }
 
 // Configure will configure the package
@@ -42,6 +42,7 @@ func Configure(conf Config) {
 		WithOpenCensusTracing: conf.WithOpenCensusTracing,
 		ServiceName:           conf.ServiceName,
 		Stage:                 conf.Stage,
+		BaseURL:               conf.BaseURL,
 	})
 }
 
@@ -62,7 +63,10 @@ func GetTokensByUser(username string) auth.Tokens {
 	return tokens[username]
 }
 
-// SignIn is thread safe and only returns new tokens if the old tokens are about to expire
+// SignIn is thread safe and only returns new tokens if the old tokens are about to expire.
+// The tokens are renewed using the refresh token when possible.
+//
+// See TokenSource for tokens that are renewed without calling SignIn.
 func SignIn(ctx context.Context, username, password string) error {
 	lock.Lock()
 	defer lock.Unlock()
@@ -71,14 +75,12 @@ func SignIn(ctx context.Context, username, password string) error {
 		return fmt.Errorf("cachedauth is not configured")
 	}
 
-	const tokenExpireDurationDiff = 5 * time.Minute
-
 	oldTokens := tokens[username]
-	if auth.IsTokenValid(oldTokens.AccessToken, tokenExpireDurationDiff) {
+	if auth.IsTokenValid(oldTokens.AccessToken, DefaultExpiryMargin) {
 		return nil
 	}
 
-	newtokens, err := auth.SignIn(ctx, username, password)
+	newtokens, err := renew(ctx, packageSigner{}, username, password, oldTokens)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,269 @@
+package cachedauth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/log"
+)
+
+// DefaultExpiryMargin is how long before they expire tokens are renewed.
+const DefaultExpiryMargin = 5 * time.Minute
+
+// retryInterval is the time the background refresh waits after a failure.
+const retryInterval = 30 * time.Second
+
+// minRefreshInterval is the shortest time the background refresh waits
+// after renewing the tokens, so tokens that can't be renewed in time don't
+// cause a sign in loop.
+const minRefreshInterval = time.Second
+
+type signer interface {
+	SignIn(ctx context.Context, username, password string) (auth.Tokens, error)
+	SignInRefreshToken(ctx context.Context, refreshToken string) (auth.Tokens, error)
+}
+
+// packageSigner signs in using the client configured with Configure.
+type packageSigner struct{}
+
+func (packageSigner) SignIn(ctx context.Context, username, password string) (auth.Tokens, error) {
+	return auth.SignIn(ctx, username, password)
+}
+
+func (packageSigner) SignInRefreshToken(ctx context.Context, refreshToken string) (auth.Tokens, error) {
+	return auth.SignInRefreshToken(ctx, refreshToken)
+}
+
+// TokenSourceConfig is the configuration of a TokenSource.
+type TokenSourceConfig struct {
+	// Client signs in the user. When not set, the client configured with
+	// Configure is used.
+	Client   *auth.Client
+	Username string
+	Password string
+	// ExpiryMargin is how long before they expire tokens are renewed.
+	// Defaults to DefaultExpiryMargin.
+	ExpiryMargin time.Duration
+}
+
+// TokenSource keeps the tokens of a single user valid.
+//
+// Tokens are renewed using the refresh token, falling back to signing in
+// with username and password when the refresh token is rejected. Concurrent
+// renewals are coalesced into a single sign in.
+type TokenSource struct {
+	signer       signer
+	username     string
+	password     string
+	expiryMargin time.Duration
+
+	refreshLock sync.Mutex
+
+	lock   sync.RWMutex
+	tokens auth.Tokens
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewTokenSource creates a TokenSource. No sign in is made until tokens
+// are requested or Start is called.
+func NewTokenSource(conf TokenSourceConfig) *TokenSource {
+	var s signer = packageSigner{}
+	if conf.Client != nil {
+		s = conf.Client
+	}
+
+	if conf.ExpiryMargin <= 0 {
+		conf.ExpiryMargin = DefaultExpiryMargin
+	}
+
+	return &TokenSource{
+		signer:       s,
+		username:     conf.Username,
+		password:     conf.Password,
+		expiryMargin: conf.ExpiryMargin,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Tokens returns the cached tokens, renewing them if they are about to
+// expire.
+func (ts *TokenSource) Tokens(ctx context.Context) (auth.Tokens, error) {
+	if tokens, valid := ts.cached(); valid {
+		return tokens, nil
+	}
+
+	ts.refreshLock.Lock()
+	defer ts.refreshLock.Unlock()
+
+	// Another caller may have renewed the tokens while we were waiting.
+	if tokens, valid := ts.cached(); valid {
+		return tokens, nil
+	}
+
+	return ts.refresh(ctx)
+}
+
+// AccessToken returns a valid access token.
+func (ts *TokenSource) AccessToken(ctx context.Context) (string, error) {
+	tokens, err := ts.Tokens(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return tokens.AccessToken, nil
+}
+
+// Invalidate makes the next call to Tokens renew the tokens, unless
+// accessToken has already been replaced. It's used when a service rejects
+// an access token that hasn't expired yet.
+func (ts *TokenSource) Invalidate(accessToken string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.tokens.AccessToken == accessToken {
+		ts.tokens.AccessToken = ""
+		ts.tokens.IdentityToken = ""
+	}
+}
+
+// Start renews the tokens in the background, twice the expiry margin or a
+// third of their lifetime before they expire, whichever is shorter, until
+// Stop is called. Callers of Tokens will then rarely have
+// to wait for a sign in.
+func (ts *TokenSource) Start() {
+	go ts.run()
+}
+
+// Stop ends the background refresh started by Start.
+func (ts *TokenSource) Stop() {
+	ts.stopOnce.Do(func() {
+		close(ts.stop)
+	})
+}
+
+func (ts *TokenSource) run() {
+	wait := time.Duration(0)
+
+	for {
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ts.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		wait = ts.untilNextRefresh()
+		if wait > 0 {
+			continue
+		}
+
+		ts.refreshLock.Lock()
+		_, err := ts.refresh(context.Background())
+		ts.refreshLock.Unlock()
+
+		if err != nil {
+			log.WithError(err).
+				WithField("username", ts.username).
+				Error("Couldn't refresh tokens")
+
+			wait = retryInterval
+
+			continue
+		}
+
+		wait = max(ts.untilNextRefresh(), minRefreshInterval)
+	}
+}
+
+// untilNextRefresh returns the time until the background refresh renews the
+// tokens, which is 0 before the first sign in. Short lived tokens are
+// renewed a third of their lifetime before they expire rather than twice
+// the expiry margin, so they aren't renewed right after being issued.
+func (ts *TokenSource) untilNextRefresh() time.Duration {
+	ts.lock.RLock()
+	accessToken := ts.tokens.AccessToken
+	ts.lock.RUnlock()
+
+	if accessToken == "" {
+		return 0
+	}
+
+	claims, ok := parseClaims(accessToken)
+	if !ok {
+		// The expiry is unknown, Tokens renews the tokens on demand.
+		return retryInterval
+	}
+
+	margin := 2 * ts.expiryMargin
+	if claims.IssuedAt != nil {
+		margin = min(margin, claims.ExpiresAt.Sub(claims.IssuedAt.Time)/3)
+	}
+
+	return time.Until(claims.ExpiresAt.Add(-margin))
+}
+
+func (ts *TokenSource) cached() (auth.Tokens, bool) {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+
+	return ts.tokens, auth.IsTokenValid(ts.tokens.AccessToken, ts.expiryMargin)
+}
+
+// refresh renews the tokens, refreshLock must be held.
+func (ts *TokenSource) refresh(ctx context.Context) (auth.Tokens, error) {
+	ts.lock.RLock()
+	old := ts.tokens
+	ts.lock.RUnlock()
+
+	tokens, err := renew(ctx, ts.signer, ts.username, ts.password, old)
+	if err != nil {
+		return auth.Tokens{}, err
+	}
+
+	ts.lock.Lock()
+	ts.tokens = tokens
+	ts.lock.Unlock()
+
+	return tokens, nil
+}
+
+// renew renews old using its refresh token, or signs in with username and
+// password if there is no refresh token or it is rejected.
+func renew(ctx context.Context, s signer, username, password string, old auth.Tokens) (auth.Tokens, error) {
+	if old.RefreshToken != "" {
+		tokens, err := s.SignInRefreshToken(ctx, old.RefreshToken)
+		if err == nil {
+			// The refresh token is only returned when signing in with password.
+			if tokens.RefreshToken == "" {
+				tokens.RefreshToken = old.RefreshToken
+			}
+
+			return tokens, nil
+		}
+
+		if ctx.Err() != nil {
+			return auth.Tokens{}, fmt.Errorf("failed to sign in with refresh token: %w", err)
+		}
+	}
+
+	return s.SignIn(ctx, username, password)
+}
+
+func parseClaims(token string) (jwt.RegisteredClaims, bool) {
+	var claims jwt.RegisteredClaims
+
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return jwt.RegisteredClaims{}, false
+	}
+
+	return claims, true
+}
@@ -0,0 +1,228 @@
+package cachedauth_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/auth/cachedauth"
+)
+
+// recordingTransport records the bodies of the sign in requests and can
+// make the SSO API reject refresh tokens.
+type recordingTransport struct {
+	rejectRefreshTokens bool
+
+	lock   sync.Mutex
+	bodies []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.lock.Lock()
+	rt.bodies = append(rt.bodies, string(body))
+	rt.lock.Unlock()
+
+	if rt.rejectRefreshTokens && strings.Contains(string(body), "refreshToken") {
+		return &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Status:     "401 Unauthorized",
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"message":"invalid refresh token"}}`)),
+			Request:    req,
+		}, nil
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (rt *recordingTransport) requests() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	return append([]string(nil), rt.bodies...)
+}
+
+func newTokenSource(t *testing.T, idp *authtest.Server, transport http.RoundTripper, margin time.Duration) *cachedauth.TokenSource {
+	t.Helper()
+
+	client, err := auth.NewClient(auth.ClientConfig{
+		BaseURL:    idp.URL,
+		HTTPClient: &http.Client{Transport: transport},
+	})
+	require.NoError(t, err)
+
+	return cachedauth.NewTokenSource(cachedauth.TokenSourceConfig{
+		Client:       client,
+		Username:     "a.b@example.com",
+		Password:     "secret",
+		ExpiryMargin: margin,
+	})
+}
+
+func Test_TokenSourceCachesTokens(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	transport := &recordingTransport{}
+	source := newTokenSource(t, idp, transport, 0)
+
+	first, err := source.Tokens(context.Background())
+	require.NoError(t, err)
+
+	second, err := source.Tokens(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Len(t, transport.requests(), 1)
+}
+
+func Test_TokenSourceRenewsWithRefreshToken(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.TokenLifetime = time.Minute
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	transport := &recordingTransport{}
+	// Tokens expiring within the margin are always renewed.
+	source := newTokenSource(t, idp, transport, time.Hour)
+
+	first, err := source.Tokens(context.Background())
+	require.NoError(t, err)
+
+	_, err = source.Tokens(context.Background())
+	require.NoError(t, err)
+
+	requests := transport.requests()
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[0], `"password":"secret"`)
+	assert.Contains(t, requests[1], `"refreshToken":"`+first.RefreshToken+`"`)
+}
+
+func Test_TokenSourceFallsBackToPassword(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.TokenLifetime = time.Minute
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	transport := &recordingTransport{rejectRefreshTokens: true}
+	source := newTokenSource(t, idp, transport, time.Hour)
+
+	_, err := source.Tokens(context.Background())
+	require.NoError(t, err)
+
+	tokens, err := source.Tokens(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	requests := transport.requests()
+	require.Len(t, requests, 3)
+	assert.Contains(t, requests[1], "refreshToken")
+	assert.Contains(t, requests[2], `"password":"secret"`)
+}
+
+func Test_TokenSourceBackgroundRefresh(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.TokenLifetime = 3 * time.Second
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	transport := &recordingTransport{}
+	source := newTokenSource(t, idp, transport, time.Second)
+
+	source.Start()
+	defer source.Stop()
+
+	require.Eventually(t, func() bool {
+		return len(transport.requests()) >= 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Contains(t, transport.requests()[1], "refreshToken")
+}
+
+func Test_TokenSourceBackgroundRefreshOfShortLivedTokens(t *testing.T) {
+	idp := authtest.NewServer(t)
+	// The tokens live shorter than twice the default expiry margin.
+	idp.TokenLifetime = 3 * time.Second
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	transport := &recordingTransport{}
+	source := newTokenSource(t, idp, transport, 0)
+
+	source.Start()
+	defer source.Stop()
+
+	// The tokens are renewed a third of their lifetime before they expire.
+	time.Sleep(time.Second)
+	assert.Len(t, transport.requests(), 1)
+
+	require.Eventually(t, func() bool {
+		return len(transport.requests()) >= 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	assert.Len(t, transport.requests(), 2)
+}
+
+func Test_TransportRetriesOnUnauthorized(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	source := newTokenSource(t, idp, &recordingTransport{}, 0)
+
+	var (
+		lock     sync.Mutex
+		seen     []string
+		rejected string
+	)
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		token := r.Header.Get("Authorization")
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		seen = append(seen, token)
+
+		// Reject the first token as if it had been revoked.
+		if rejected == "" || rejected == token {
+			rejected = token
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		w.Write(body) //nolint:errcheck
+	}))
+	defer service.Close()
+
+	client := &http.Client{Transport: &cachedauth.Transport{Source: source}}
+
+	resp, err := client.Post(service.URL, "text/plain", strings.NewReader("payload"))
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "payload", string(body))
+
+	require.Len(t, seen, 2)
+	assert.NotEmpty(t, seen[0])
+	assert.NotEqual(t, seen[0], seen[1])
+}
@@ -0,0 +1,84 @@
+package cachedauth
+
+import (
+	"fmt"
+	"net/http"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+)
+
+// Transport is an http.RoundTripper that adds an access token from Source
+// to the Authorization header of each request.
+//
+// If the response is 401 Unauthorized the access token is invalidated and
+// the request is retried once with a new access token. Requests with a body
+// are only retried when the body can be recreated using Request.GetBody,
+// which http.NewRequest sets up for the common body types.
+type Transport struct {
+	Source *TokenSource
+	// Base is the RoundTripper used to make the requests.
+	// Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, accessToken, err := t.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized || !canRetry(req) {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	t.Source.Invalidate(accessToken)
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to recreate request body: %w", err)
+		}
+
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	resp, _, err = t.roundTrip(req)
+
+	return resp, err
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, string, error) {
+	accessToken, err := t.Source.AccessToken(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	// A RoundTripper must not modify the request.
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set(http_model.HeaderAuthorization, accessToken)
+
+	resp, err := t.base().RoundTrip(authorized)
+
+	return resp, accessToken, err
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+
+	return http.DefaultTransport
+}
+
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
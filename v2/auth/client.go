@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	oc_http "go.opencensus.io/plugin/ochttp"
+	dd_http "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+)
+
+// Tracing selects how a Client traces its requests.
+type Tracing int
+
+const (
+	TracingOpenCensus Tracing = iota // default
+	TracingDatadog
+	TracingNone
+)
+
+// ClientConfig is the configuration of a Client.
+type ClientConfig struct {
+	Tracing     Tracing
+	ServiceName string // needed when using lambda and Datadog for tracing
+	Stage       string
+	BaseURL     string // overrides the URL derived from Stage
+	// HTTPClient is used as is when set, Tracing and Timeout are then ignored.
+	HTTPClient *http.Client
+	// Timeout limits each request to the SSO API. Zero means no timeout.
+	Timeout time.Duration
+	// ChallengeHandler answers challenges returned during sign in. When not
+	// set, NEW_PASSWORD_REQUIRED challenges are completed by reusing the
+	// password and other challenges fail the sign in.
+	ChallengeHandler ChallengeHandler
+}
+
+// Challenge types the SSO API can return during sign in.
+const (
+	ChallengeNewPasswordRequired = "NEW_PASSWORD_REQUIRED"
+	ChallengeSoftwareTokenMFA    = "SOFTWARE_TOKEN_MFA"
+	ChallengeSMSMFA              = "SMS_MFA"
+)
+
+// maxChallenges limits how many challenges in a row a sign in may return.
+const maxChallenges = 5
+
+// ChallengeHandler answers a challenge returned during sign in with the
+// properties the challenge is completed with.
+type ChallengeHandler func(ctx context.Context, challenge Challenge) (properties map[string]string, err error)
+
+// NewPasswordProperties are the properties completing a
+// NEW_PASSWORD_REQUIRED challenge.
+func NewPasswordProperties(newPassword string) map[string]string {
+	return map[string]string{"newPassword": newPassword}
+}
+
+// Client signs in against the SSO API of a single stage. Unlike the package
+// level functions it doesn't depend on global configuration, so several
+// clients for different stages can be used in the same process.
+type Client struct {
+	baseURL          string
+	httpClient       *http.Client
+	challengeHandler ChallengeHandler
+}
+
+// NewClient creates a Client. It fails if neither BaseURL nor an allowed
+// Stage is configured.
+func NewClient(conf ClientConfig) (*Client, error) {
+	baseURL := conf.BaseURL
+	if baseURL == "" {
+		var err error
+		if baseURL, err = baseURLForStage(conf.Stage); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := conf.HTTPClient
+	if httpClient == nil {
+		httpClient = newHTTPClient(conf)
+	}
+
+	return &Client{
+		baseURL:          baseURL,
+		httpClient:       httpClient,
+		challengeHandler: conf.ChallengeHandler,
+	}, nil
+}
+
+func newHTTPClient(conf ClientConfig) *http.Client {
+	client := &http.Client{Timeout: conf.Timeout}
+
+	switch conf.Tracing {
+	case TracingOpenCensus:
+		client.Transport = new(oc_http.Transport)
+	case TracingDatadog:
+		client = withDatadogTracing(conf.ServiceName, client)
+	case TracingNone:
+	}
+
+	return client
+}
+
+// BaseURL returns the URL of the SSO API the client signs in against.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// SignIn will sign in the user and complete any challenges returned by the
+// SSO API using the configured ChallengeHandler.
+func (c *Client) SignIn(ctx context.Context, username, password string) (Tokens, error) {
+	resp, err := c.initiateSignIn(ctx, initiateSignInRequest{Username: username, Password: password})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("failed to initiate sign in: %w", err)
+	}
+
+	for range maxChallenges {
+		challenge := resp.Data.Challenge
+		if challenge.Type == "" {
+			return resp.Data.Tokens, nil
+		}
+
+		properties, err := c.answerChallenge(ctx, challenge, password)
+		if err != nil {
+			return Tokens{}, fmt.Errorf("failed to answer %s challenge: %w", challenge.Type, err)
+		}
+
+		resp, err = c.completeSignIn(ctx, completeSignInRequest{
+			Username:   username,
+			ID:         challenge.ID,
+			Type:       challenge.Type,
+			Properties: properties,
+		})
+		if err != nil {
+			return Tokens{}, fmt.Errorf("failed to complete sign in: %w", err)
+		}
+	}
+
+	return Tokens{}, fmt.Errorf("failed to complete sign in: more than %d challenges", maxChallenges)
+}
+
+func (c *Client) answerChallenge(ctx context.Context, challenge Challenge, password string) (map[string]string, error) {
+	if c.challengeHandler != nil {
+		return c.challengeHandler(ctx, challenge)
+	}
+
+	if challenge.Type == ChallengeNewPasswordRequired {
+		return NewPasswordProperties(password), nil
+	}
+
+	return nil, fmt.Errorf("no challenge handler configured")
+}
+
+func (c *Client) SignInRefreshToken(ctx context.Context, refreshToken string) (Tokens, error) {
+	resp, err := c.initiateSignIn(ctx, initiateSignInRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("failed to sign in with refreshtoken: %w", err)
+	}
+
+	tokens := Tokens{
+		AccessToken:   resp.Data.Tokens.AccessToken,
+		IdentityToken: resp.Data.Tokens.IdentityToken,
+		RefreshToken:  resp.Data.Tokens.RefreshToken,
+	}
+
+	return tokens, nil
+}
+
+type initiateSignInRequest struct {
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type completeSignInRequest struct {
+	Username   string            `json:"username"`
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+}
+
+func (c *Client) initiateSignIn(ctx context.Context, body initiateSignInRequest) (SignInResponse, error) {
+	const endpoint = "/sign-in/initiate"
+
+	return c.signIn(ctx, endpoint, body)
+}
+
+func (c *Client) completeSignIn(ctx context.Context, body completeSignInRequest) (SignInResponse, error) {
+	const endpoint = "/sign-in/complete"
+
+	return c.signIn(ctx, endpoint, body)
+}
+
+func (c *Client) signIn(ctx context.Context, endpoint string, body any) (SignInResponse, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return SignInResponse{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return SignInResponse{}, fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return SignInResponse{}, fmt.Errorf("failed to execute HTTP request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		if err = json.NewDecoder(resp.Body).Decode(&errorResp); err != nil {
+			return SignInResponse{}, fmt.Errorf("failed to decode Error response to JSON: %w", err)
+		}
+
+		return SignInResponse{}, fmt.Errorf("status code: %s, error message: %s", resp.Status, errorResp.Error.Message)
+	}
+
+	var signInResp SignInResponse
+
+	if err = json.NewDecoder(resp.Body).Decode(&signInResp); err != nil {
+		return SignInResponse{}, fmt.Errorf("failed to decode Sign In response to JSON: %w", err)
+	}
+
+	return signInResp, nil
+}
+
+func withDatadogTracing(serviceName string, client *http.Client) *http.Client {
+	resourceNamer := func(req *http.Request) string {
+		return fmt.Sprintf("%s %s", req.Method, req.URL.String())
+	}
+
+	var opts = []dd_http.RoundTripperOption{
+		dd_http.RTWithResourceNamer(resourceNamer),
+	}
+
+	if serviceName != "" {
+		opts = append(opts, dd_http.RTWithServiceName(serviceName))
+	}
+
+	return dd_http.WrapClient(client, opts...)
+}
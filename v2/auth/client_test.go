@@ -0,0 +1,147 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/jwk"
+	"github.com/SKF/go-utility/v2/jwt"
+)
+
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_ClientsForSeveralStages(t *testing.T) {
+	ctx := context.Background()
+
+	sandbox := authtest.NewServer(t)
+	sandbox.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret", UserID: "sandbox-user"})
+
+	staging := authtest.NewServer(t)
+	staging.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret", UserID: "staging-user"})
+
+	transport := &countingTransport{}
+
+	sandboxClient, err := auth.NewClient(auth.ClientConfig{
+		BaseURL:    sandbox.URL,
+		HTTPClient: &http.Client{Transport: transport},
+	})
+	require.NoError(t, err)
+
+	stagingClient, err := auth.NewClient(auth.ClientConfig{BaseURL: staging.URL, Tracing: auth.TracingNone})
+	require.NoError(t, err)
+
+	for client, idp := range map[*auth.Client]*authtest.Server{sandboxClient: sandbox, stagingClient: staging} {
+		tokens, err := client.SignIn(ctx, "a.b@example.com", "secret")
+		require.NoError(t, err)
+
+		provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+		token, err := jwt.Parse(tokens.IdentityToken, jwt.WithKeyProvider(provider))
+		require.NoError(t, err)
+		assert.Equal(t, idp.URL, token.GetClaims().Issuer)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.requests))
+}
+
+func Test_NewClientRequiresStageOrBaseURL(t *testing.T) {
+	_, err := auth.NewClient(auth.ClientConfig{Stage: "unknown"})
+	require.Error(t, err)
+
+	client, err := auth.NewClient(auth.ClientConfig{Stage: "sandbox"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://sso-api.sandbox.users.enlight.skf.com", client.BaseURL())
+}
+
+func Test_PackageFunctionsUseConfiguredClient(t *testing.T) {
+	auth.Configure(auth.Config{Stage: "unknown"})
+
+	_, err := auth.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.Error(t, err)
+
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	auth.Configure(auth.Config{BaseURL: idp.URL})
+
+	tokens, err := auth.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+}
+
+func Test_SignInEncodesCredentials(t *testing.T) {
+	const password = `p"a\ss", "injected": "field`
+
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: `a"b@example.com`, Password: password})
+
+	client, err := auth.NewClient(auth.ClientConfig{BaseURL: idp.URL})
+	require.NoError(t, err)
+
+	tokens, err := client.SignIn(context.Background(), `a"b@example.com`, password)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+}
+
+func Test_SignInChallengeHandler(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{
+		Username:            "a.b@example.com",
+		Password:            "secret",
+		NewPasswordRequired: true,
+		MFACode:             "123456",
+	})
+
+	var answered []string
+
+	client, err := auth.NewClient(auth.ClientConfig{
+		BaseURL: idp.URL,
+		ChallengeHandler: func(_ context.Context, challenge auth.Challenge) (map[string]string, error) {
+			answered = append(answered, challenge.Type)
+
+			switch challenge.Type {
+			case auth.ChallengeNewPasswordRequired:
+				return auth.NewPasswordProperties("new secret"), nil
+			case auth.ChallengeSoftwareTokenMFA:
+				return map[string]string{"code": "123456"}, nil
+			}
+
+			return nil, errors.New("unexpected challenge")
+		},
+	})
+	require.NoError(t, err)
+
+	tokens, err := client.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.Equal(t, []string{auth.ChallengeNewPasswordRequired, auth.ChallengeSoftwareTokenMFA}, answered)
+
+	_, err = client.SignIn(context.Background(), "a.b@example.com", "new secret")
+	require.NoError(t, err)
+}
+
+func Test_SignInUnhandledChallenge(t *testing.T) {
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret", MFACode: "123456"})
+
+	client, err := auth.NewClient(auth.ClientConfig{BaseURL: idp.URL})
+	require.NoError(t, err)
+
+	_, err = client.SignIn(context.Background(), "a.b@example.com", "secret")
+	require.ErrorContains(t, err, auth.ChallengeSoftwareTokenMFA)
+}
@@ -10,19 +10,35 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/SKF/go-utility/v2/auth"
 )
 
+// DefaultSecretTTL is how long fetched credentials are used before the
+// secret is fetched again.
+const DefaultSecretTTL = time.Hour
+
 var tokensMutex = new(sync.RWMutex)
 var tokens auth.Tokens
 var tokenExpireDurationDiff = 5 * time.Minute
 
-var fetchingTokensMutex = new(sync.RWMutex)
-var fetchingTokens bool
+// signInGroup makes concurrent calls to SignIn share a single sign in.
+var signInGroup singleflight.Group
+
+var credentialsMutex = new(sync.Mutex)
+var cachedCredentials *credentials
 
 var config *Config
 
+// SecretsManagerClient is the part of the Secrets Manager client used by
+// the package.
+type SecretsManagerClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+var _ SecretsManagerClient = (*secretsmanager.Client)(nil)
+
 // Config is the configuration of the package
 type Config struct {
 	WithDatadogTracing       bool   // used when you trace your application with Datadog
@@ -33,22 +49,57 @@ type Config struct {
 	AWSSecretsManagerRegion  string
 	SecretKey                string
 	Stage                    string
+	BaseURL                  string // overrides the URL derived from Stage
+	// SecretsManagerClient is used to fetch the secret, defaults to a client
+	// created from AWSConfig.
+	SecretsManagerClient SecretsManagerClient
+	// SecretTTL is how long fetched credentials are used before the secret
+	// is fetched again, defaults to DefaultSecretTTL.
+	SecretTTL time.Duration
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	versionID string
+	fetchedAt time.Time
 }
 
 // Configure will configure the package
 func Configure(conf Config) {
 	conf.WithOpenCensusTracing = !conf.WithDatadogTracing
+
+	if conf.SecretsManagerClient == nil {
+		conf.SecretsManagerClient = secretsmanager.NewFromConfig(conf.AWSConfig)
+	}
+
+	if conf.SecretTTL <= 0 {
+		conf.SecretTTL = DefaultSecretTTL
+	}
+
 	config = &conf
 
+	credentialsMutex.Lock()
+	cachedCredentials = nil
+	credentialsMutex.Unlock()
+
+	tokensMutex.Lock()
+	tokens = auth.Tokens{}
+	tokensMutex.Unlock()
+
 	auth.Configure(auth.Config{
 		WithDatadogTracing:    conf.WithDatadogTracing,
 		WithOpenCensusTracing: conf.WithOpenCensusTracing,
 		ServiceName:           conf.ServiceName,
 		Stage:                 conf.Stage,
+		BaseURL:               conf.BaseURL,
 	})
 }
 
 // GetTokens will return the cached tokens
+//
+// GetTokens waits for an in-flight SignIn to finish.
 func GetTokens() auth.Tokens {
 	tokensMutex.RLock()
 	defer tokensMutex.RUnlock()
@@ -57,71 +108,121 @@ func GetTokens() auth.Tokens {
 }
 
 // SignIn will fetch credentials from the Secret Manager and Sign In using those credentials
-func SignIn(ctx context.Context) (err error) {
+//
+// SignIn is thread safe, concurrent callers wait for and share the result of
+// a single sign in. The tokens are only renewed when they are about to
+// expire.
+func SignIn(ctx context.Context) error {
 	if config == nil {
 		return errors.New("secretsmanagerauth is not configured")
 	}
 
-	// handle multiple concurrent calls to secretsmanagerlogin.SignIn
-	fetchingTokensMutex.RLock()
-	if fetchingTokens {
-		fetchingTokensMutex.RUnlock()
-		return nil
+	// The lock is held while signing in, and the caller then waits for the
+	// sign in below where ctx is honored.
+	if tokensMutex.TryRLock() {
+		valid := auth.IsTokenValid(tokens.AccessToken, tokenExpireDurationDiff)
+		tokensMutex.RUnlock()
+
+		if valid {
+			return nil
+		}
 	}
-	fetchingTokensMutex.RUnlock()
 
-	// will make calls to secretsmanagerlogin.GetTokens to wait for secretsmanagerlogin.SignIn to finish
-	tokensMutex.Lock()
-	defer tokensMutex.Unlock()
+	// The sign in is shared with other callers and must not be canceled
+	// when the context of this caller is.
+	result := signInGroup.DoChan("", func() (any, error) {
+		return nil, signInIfExpired(context.WithoutCancel(ctx))
+	})
 
-	fetchingTokensMutex.Lock()
-	fetchingTokens = true
-	fetchingTokensMutex.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-result:
+		return res.Err
+	}
+}
 
-	defer func() {
-		fetchingTokensMutex.Lock()
-		fetchingTokens = false
-		fetchingTokensMutex.Unlock()
-	}()
+// signInIfExpired holds the tokens lock during the sign in, which makes
+// GetTokens wait for the new tokens.
+func signInIfExpired(ctx context.Context) error {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
 
 	if auth.IsTokenValid(tokens.AccessToken, tokenExpireDurationDiff) {
 		return nil
 	}
 
-	tokens, err = signIn(ctx)
+	newTokens, err := signIn(ctx)
+	tokens = newTokens
+
+	return err
+}
+
+func signIn(ctx context.Context) (auth.Tokens, error) {
+	creds, err := getCredentials(ctx, false)
 	if err != nil {
-		tokens = auth.Tokens{}
-		return err
+		return auth.Tokens{}, err
+	}
+
+	tokens, err := auth.SignIn(ctx, creds.Username, creds.Password)
+	if err == nil {
+		return tokens, nil
+	}
+
+	// The secret may have been rotated since the credentials were cached.
+	rotated, fetchErr := getCredentials(ctx, true)
+	if fetchErr != nil || rotated.versionID == creds.versionID {
+		return auth.Tokens{}, fmt.Errorf("failed to sign in: %w", err)
+	}
+
+	if tokens, err = auth.SignIn(ctx, rotated.Username, rotated.Password); err != nil {
+		return auth.Tokens{}, fmt.Errorf("failed to sign in: %w", err)
 	}
 
-	return nil
+	return tokens, nil
 }
 
-func signIn(ctx context.Context) (tokens auth.Tokens, err error) {
-	svc := secretsmanager.NewFromConfig(config.AWSConfig)
+// getCredentials returns the cached credentials, fetching the secret if the
+// credentials are older than the secret TTL or refetch is set.
+func getCredentials(ctx context.Context, refetch bool) (credentials, error) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	if !refetch && cachedCredentials != nil && time.Since(cachedCredentials.fetchedAt) < config.SecretTTL {
+		return *cachedCredentials, nil
+	}
+
+	creds, err := fetchCredentials(ctx)
+	if err != nil {
+		return credentials{}, err
+	}
 
+	cachedCredentials = &creds
+
+	return creds, nil
+}
+
+func fetchCredentials(ctx context.Context) (creds credentials, err error) {
 	secretKey := "arn:aws:secretsmanager:" + config.AWSSecretsManagerRegion + ":" + config.AWSSecretsManagerAccount + ":secret:" + config.SecretKey
 
-	output, err := svc.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &secretKey})
+	output, err := config.SecretsManagerClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &secretKey})
 	if err != nil {
 		err = fmt.Errorf("failed to get secret value: %w", err)
 		return
 	}
 
-	var secret struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	value := output.SecretBinary
+	if output.SecretString != nil {
+		value = []byte(*output.SecretString)
 	}
 
-	if err = json.Unmarshal(output.SecretBinary, &secret); err != nil {
+	if err = json.Unmarshal(value, &creds); err != nil {
 		err = fmt.Errorf("failed to unmarshal secret value: %w", err)
 		return
 	}
 
-	if tokens, err = auth.SignIn(ctx, secret.Username, secret.Password); err != nil {
-		err = fmt.Errorf("failed to sign in: %w", err)
-		return
-	}
+	creds.versionID = aws.ToString(output.VersionId)
+	creds.fetchedAt = time.Now()
 
-	return tokens, err
+	return creds, nil
 }
@@ -0,0 +1,283 @@
+package secretsmanagerauth_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/auth/secretsmanagerauth"
+)
+
+// These tests configure global variables in the package and can't be run
+// in parallel.
+
+type secretVersion struct {
+	id     string
+	secret string
+}
+
+type fakeSecretsManager struct {
+	lock     sync.Mutex
+	versions []secretVersion
+	binary   bool
+	err      error
+	calls    int
+	// release blocks GetSecretValue until closed, when set.
+	release chan struct{}
+	// started is sent to when GetSecretValue is called, when set.
+	started chan struct{}
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, _ *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+
+	if f.release != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-f.release:
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	// Each call returns the next version, the last version is kept.
+	version := f.versions[0]
+	if len(f.versions) > 1 {
+		f.versions = f.versions[1:]
+	}
+
+	output := &secretsmanager.GetSecretValueOutput{VersionId: aws.String(version.id)}
+	if f.binary {
+		output.SecretBinary = []byte(version.secret)
+	} else {
+		output.SecretString = aws.String(version.secret)
+	}
+
+	return output, nil
+}
+
+func (f *fakeSecretsManager) callCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.calls
+}
+
+const credentialsJSON = `{"username": "a.b@example.com", "password": "secret"}`
+
+func configure(t *testing.T, fake *fakeSecretsManager, secretTTL time.Duration) *authtest.Server {
+	t.Helper()
+
+	idp := authtest.NewServer(t)
+	idp.AddUser(authtest.User{Username: "a.b@example.com", Password: "secret"})
+
+	secretsmanagerauth.Configure(secretsmanagerauth.Config{
+		BaseURL:              idp.URL,
+		SecretKey:            "user-credentials/service",
+		SecretsManagerClient: fake,
+		SecretTTL:            secretTTL,
+	})
+
+	return idp
+}
+
+func Test_SignInConcurrentCallersShareResult(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{{id: "1", secret: credentialsJSON}},
+		release:  make(chan struct{}),
+	}
+	configure(t, fake, 0)
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, callers)
+
+	for range callers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs <- secretsmanagerauth.SignIn(context.Background())
+		}()
+	}
+
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.NotEmpty(t, secretsmanagerauth.GetTokens().AccessToken)
+	assert.Equal(t, 1, fake.callCount())
+}
+
+func Test_SignInConcurrentCallersShareError(t *testing.T) {
+	fake := &fakeSecretsManager{
+		err:     errors.New("access denied"),
+		release: make(chan struct{}),
+	}
+	configure(t, fake, 0)
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, callers)
+
+	for range callers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs <- secretsmanagerauth.SignIn(context.Background())
+		}()
+	}
+
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.ErrorContains(t, err, "access denied")
+	}
+
+	assert.Empty(t, secretsmanagerauth.GetTokens().AccessToken)
+}
+
+func Test_SignInCanceledCallerDoesNotCancelSignIn(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{{id: "1", secret: credentialsJSON}},
+		release:  make(chan struct{}),
+	}
+	configure(t, fake, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+
+	go func() {
+		done <- secretsmanagerauth.SignIn(ctx)
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	close(fake.release)
+
+	require.NoError(t, secretsmanagerauth.SignIn(context.Background()))
+	assert.NotEmpty(t, secretsmanagerauth.GetTokens().AccessToken)
+	assert.Equal(t, 1, fake.callCount())
+}
+
+func Test_GetTokensWaitsForSignIn(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{{id: "1", secret: credentialsJSON}},
+		release:  make(chan struct{}),
+		started:  make(chan struct{}, 1),
+	}
+	configure(t, fake, 0)
+
+	signedIn := make(chan error)
+
+	go func() {
+		signedIn <- secretsmanagerauth.SignIn(context.Background())
+	}()
+
+	<-fake.started
+
+	got := make(chan string)
+
+	go func() {
+		got <- secretsmanagerauth.GetTokens().AccessToken
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("GetTokens didn't wait for the sign in")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fake.release)
+
+	assert.NotEmpty(t, <-got)
+	require.NoError(t, <-signedIn)
+}
+
+func Test_SignInSecretBinary(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{{id: "1", secret: credentialsJSON}},
+		binary:   true,
+	}
+	configure(t, fake, 0)
+
+	require.NoError(t, secretsmanagerauth.SignIn(context.Background()))
+	assert.NotEmpty(t, secretsmanagerauth.GetTokens().AccessToken)
+}
+
+func Test_SignInRefetchesRotatedSecret(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{
+			{id: "1", secret: `{"username": "a.b@example.com", "password": "old"}`},
+			{id: "2", secret: credentialsJSON},
+		},
+	}
+	configure(t, fake, 0)
+
+	require.NoError(t, secretsmanagerauth.SignIn(context.Background()))
+	assert.NotEmpty(t, secretsmanagerauth.GetTokens().AccessToken)
+	assert.Equal(t, 2, fake.callCount())
+}
+
+func Test_SignInWrongCredentialsWithoutRotation(t *testing.T) {
+	fake := &fakeSecretsManager{
+		versions: []secretVersion{{id: "1", secret: `{"username": "a.b@example.com", "password": "wrong"}`}},
+	}
+	configure(t, fake, 0)
+
+	require.Error(t, secretsmanagerauth.SignIn(context.Background()))
+	assert.Equal(t, 2, fake.callCount())
+}
+
+func Test_SignInCachesSecret(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		secretTTL time.Duration
+		calls     int
+	}{
+		{name: "within TTL", secretTTL: time.Hour, calls: 1},
+		{name: "after TTL", secretTTL: time.Nanosecond, calls: 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeSecretsManager{versions: []secretVersion{{id: "1", secret: credentialsJSON}}}
+			idp := configure(t, fake, test.secretTTL)
+			// Tokens expiring within five minutes are always renewed.
+			idp.TokenLifetime = time.Minute
+
+			require.NoError(t, secretsmanagerauth.SignIn(context.Background()))
+			require.NoError(t, secretsmanagerauth.SignIn(context.Background()))
+			assert.Equal(t, test.calls, fake.callCount())
+		})
+	}
+}
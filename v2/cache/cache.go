@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
@@ -19,6 +20,7 @@ const (
 type Cache struct {
 	cache          *ristretto.Cache
 	log            log.Logger
+	lock           sync.Mutex // guards gets, sets and perFuncMetrics
 	gets           uint64
 	sets           uint64
 	perFuncMetrics map[string]*perFuncMetric
@@ -47,14 +49,14 @@ func New(ttl time.Duration, cacheSizeMaxMB int64) (*Cache, error) {
 		return nil, fmt.Errorf("error creating cache: %w", err)
 	}
 
-	obj := Cache{
+	obj := &Cache{
 		cache:          memcache,
 		ttl:            ttl,
 		log:            log.Base(),
 		perFuncMetrics: make(map[string]*perFuncMetric),
 	}
 
-	return &obj, nil
+	return obj, nil
 }
 
 func (c *Cache) SetLogger(logger log.Logger) {
@@ -66,10 +68,16 @@ func (c *Cache) Clear() {
 }
 
 func (c *Cache) Sets() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	return c.sets
 }
 
 func (c *Cache) Gets() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	return c.gets
 }
 
@@ -220,3 +220,30 @@ func Test_CacheTestPerFuncKeyCounters(t *testing.T) {
 	assert.Equal(t, expectedGets/2, int(c.perFuncMetrics[key2.FuncName()].gets))
 	assert.Equal(t, expectedHits/2, int(c.perFuncMetrics[key2.FuncName()].hits))
 }
+
+func Test_CacheSetWithTTL(t *testing.T) {
+	c := makeCache(t, TTL)
+
+	require.True(t, c.SetWithTTL(key, 1, 10*TTL))
+	time.Sleep(TTL + wait)
+
+	assert.True(t, c.Exist(key))
+}
+
+func Test_CacheSetWithTTLNoTTL(t *testing.T) {
+	c := makeCache(t, NoTTL)
+
+	assert.False(t, c.SetWithTTL(key, 1, TTL))
+}
+
+func Test_CacheDelete(t *testing.T) {
+	c := makeCache(t, TTL)
+
+	require.True(t, c.Set(key, 1))
+	time.Sleep(wait)
+
+	c.Delete(key)
+	assert.False(t, c.Exist(key))
+	assert.Equal(t, 1, int(c.FuncGets(cacheFuncKey)))
+	assert.Equal(t, 0, int(c.FuncHits(cacheFuncKey)))
+}
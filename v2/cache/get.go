@@ -10,12 +10,15 @@ func (c *Cache) Get(key ObjectKey) (obj interface{}, ok bool) {
 		return nil, false
 	}
 
+	data, found := c.cache.Get(string(key))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	if _, ok := c.perFuncMetrics[key.FuncName()]; !ok {
 		c.perFuncMetrics[key.FuncName()] = &perFuncMetric{}
 	}
 
-	data, found := c.cache.Get(string(key))
-
 	c.gets++
 	c.perFuncMetrics[key.FuncName()].gets++
 
@@ -25,3 +28,27 @@ func (c *Cache) Get(key ObjectKey) (obj interface{}, ok bool) {
 
 	return data, found
 }
+
+// FuncGets returns the number of gets of keys created by funcName.
+func (c *Cache) FuncGets(funcName string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if metric, ok := c.perFuncMetrics[funcName]; ok {
+		return metric.gets
+	}
+
+	return 0
+}
+
+// FuncHits returns the number of hits of keys created by funcName.
+func (c *Cache) FuncHits(funcName string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if metric, ok := c.perFuncMetrics[funcName]; ok {
+		return metric.hits
+	}
+
+	return 0
+}
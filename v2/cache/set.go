@@ -1,11 +1,26 @@
 package cache
 
+import "time"
+
 func (c *Cache) Set(key ObjectKey, value interface{}) bool {
-	if c.ttl <= 0 {
+	return c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL sets the value using ttl instead of the TTL of the cache.
+// Nothing is set when caching is disabled by a non-positive cache TTL.
+func (c *Cache) SetWithTTL(key ObjectKey, value interface{}, ttl time.Duration) bool {
+	if c.ttl <= 0 || ttl <= 0 {
 		return false
 	}
 
+	c.lock.Lock()
 	c.sets++
+	c.lock.Unlock()
+
+	return c.cache.SetWithTTL(string(key), value, 1, ttl)
+}
 
-	return c.cache.SetWithTTL(string(key), value, 1, c.ttl)
+// Delete removes the value of key.
+func (c *Cache) Delete(key ObjectKey) {
+	c.cache.Del(string(key))
 }
@@ -0,0 +1,81 @@
+// Package claimscontext stores the claims of a validated token in a context.
+//
+// NewContext also populates the accesstokensubcontext, useridcontext and
+// impersonatercontext packages, so code reading the subject, user ID or
+// author ID from those keeps working.
+package claimscontext
+
+import (
+	"context"
+	"strings"
+
+	"github.com/SKF/go-utility/v2/accesstokensubcontext"
+	"github.com/SKF/go-utility/v2/impersonatercontext"
+	"github.com/SKF/go-utility/v2/jwt"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+const (
+	userIDPrefix   = "enlightUserId:"
+	authorIDPrefix = "authorId:"
+)
+
+type claimsContextKey struct{}
+
+type rawTokenContextKey struct{}
+
+// FromContext extracts the claims of the validated token from a context.
+func FromContext(ctx context.Context) (jwt.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(jwt.Claims)
+	return claims, ok
+}
+
+// RawTokenFromContext extracts the validated token, as it was received,
+// from a context.
+func RawTokenFromContext(ctx context.Context) (string, bool) {
+	rawToken, ok := ctx.Value(rawTokenContextKey{}).(string)
+	return rawToken, ok
+}
+
+// NewContext adds the claims and raw token of a validated token to a new
+// context, together with the subject, user ID and author ID of the token.
+func NewContext(ctx context.Context, claims jwt.Claims, rawToken string) context.Context {
+	userID, authorID := UserAndAuthor(claims)
+
+	ctx = context.WithValue(ctx, claimsContextKey{}, claims)
+	ctx = context.WithValue(ctx, rawTokenContextKey{}, rawToken)
+	ctx = accesstokensubcontext.NewContext(ctx, claims.Subject)
+	ctx = useridcontext.NewContext(ctx, userID)
+	ctx = impersonatercontext.NewContext(ctx, authorID)
+
+	return ctx
+}
+
+// UserAndAuthor returns the user and author ID of the claims.
+//
+// userID is the Enlight User ID of the authenticated/impersonated user.
+// authorID is the Enlight User ID of the authenticated user who creates the token.
+// If token is generated for impersonation, author indicates the admin user who wants to impersonate.
+// If it is a normal token, authorID and userID are the same.
+// We added these two fields to all the tokens to make sure that it will be consistent between the services.
+func UserAndAuthor(claims jwt.Claims) (userID string, authorID string) {
+	for _, group := range claims.CognitoGroups {
+		if strings.HasPrefix(group, userIDPrefix) {
+			if len(group) == len(userIDPrefix) { // nothing after the prefix
+				continue
+			}
+
+			userID = group[len(userIDPrefix):]
+		}
+
+		if strings.HasPrefix(group, authorIDPrefix) {
+			if len(group) == len(authorIDPrefix) { // nothing after the prefix
+				continue
+			}
+
+			authorID = group[len(authorIDPrefix):]
+		}
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package claimscontext_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/accesstokensubcontext"
+	"github.com/SKF/go-utility/v2/claimscontext"
+	"github.com/SKF/go-utility/v2/impersonatercontext"
+	"github.com/SKF/go-utility/v2/jwt"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+func Test_NewContext(t *testing.T) {
+	var claims jwt.Claims
+	claims.Subject = "subject"
+	claims.EnlightCompanyID = "company"
+	claims.CognitoGroups = []string{"enlightUserId:user", "authorId:admin", "operators"}
+
+	ctx := claimscontext.NewContext(context.Background(), claims, "raw")
+
+	stored, ok := claimscontext.FromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "company", stored.EnlightCompanyID)
+
+	rawToken, ok := claimscontext.RawTokenFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "raw", rawToken)
+
+	subject, _ := accesstokensubcontext.FromContext(ctx)
+	userID, _ := useridcontext.FromContext(ctx)
+	authorID, _ := impersonatercontext.FromContext(ctx)
+	assert.Equal(t, []string{"subject", "user", "admin"}, []string{subject, userID, authorID})
+}
+
+func Test_FromEmptyContext(t *testing.T) {
+	_, ok := claimscontext.FromContext(context.Background())
+	assert.False(t, ok)
+
+	_, ok = claimscontext.RawTokenFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func Test_UserAndAuthor(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		groups   []string
+		userID   string
+		authorID string
+	}{
+		{name: "no groups"},
+		{name: "user and author", groups: []string{"enlightUserId:user", "authorId:user"}, userID: "user", authorID: "user"},
+		{name: "empty values", groups: []string{"enlightUserId:", "authorId:"}},
+		{name: "other groups", groups: []string{"operators", "enlightUserId:user"}, userID: "user"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var claims jwt.Claims
+			claims.CognitoGroups = test.groups
+
+			userID, authorID := claimscontext.UserAndAuthor(claims)
+			assert.Equal(t, test.userID, userID)
+			assert.Equal(t, test.authorID, authorID)
+		})
+	}
+}
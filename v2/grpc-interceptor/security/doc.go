@@ -0,0 +1,36 @@
+// Package security contains gRPC server interceptors handling authentication
+// and authorization, like AuthenticateMiddlewareV3 and AuthorizeMiddleware in
+// the http-middleware package.
+//
+// The token is read from the "authorization" metadata, with or without a
+// "Bearer " prefix, and validated with jwt.Parse. The claims are stored using
+// the claimscontext package, which also populates the useridcontext,
+// impersonatercontext and accesstokensubcontext packages.
+//
+// Every method requires a valid token unless it's configured as Public.
+//
+// The ResourceFuncs of streaming methods are called before any message is
+// received, with a nil request message. Use a comma-ok type assertion on the
+// request, so a ResourceFunc used for a streaming method returns an error
+// instead of panicking.
+//
+// # Examples
+//
+//	sec := security.New(security.WithAuthorizer(authorizerClient))
+//
+//	sec.HandleSecureMethod("/grpc.health.v1.Health/Check").Public()
+//	sec.HandleSecureMethod("/assets.Assets/GetAsset").
+//	    Authorize("asset/read", func(_ context.Context, req any) (*common.Origin, error) {
+//	        input, ok := req.(*assets.GetAssetInput)
+//	        if !ok {
+//	            return nil, status.Error(codes.InvalidArgument, "unexpected request")
+//	        }
+//
+//	        return &common.Origin{Id: input.Id, Type: "asset"}, nil
+//	    })
+//
+//	server := grpc.NewServer(
+//	    grpc.ChainUnaryInterceptor(sec.UnaryServerInterceptor()),
+//	    grpc.ChainStreamInterceptor(sec.StreamServerInterceptor()),
+//	)
+package security
@@ -0,0 +1,282 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/SKF/proto/v2/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SKF/go-utility/v2/claimscontext"
+	"github.com/SKF/go-utility/v2/jwk"
+	"github.com/SKF/go-utility/v2/jwt"
+	"github.com/SKF/go-utility/v2/log"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+// MetadataAuthorization is the metadata key the token is read from.
+const MetadataAuthorization = "authorization"
+
+const bearerPrefix = "bearer "
+
+// Authorizer decides if a user may perform an action on a resource. It has
+// the same method as httpmiddleware.Authorizer, so the same authorizer can be
+// used for both.
+type Authorizer interface {
+	IsAuthorizedWithContext(ctx context.Context, userID, action string, resource *common.Origin) (bool, error)
+}
+
+// ResourceFunc returns the resource to use for authorization from the
+// request message. For streaming methods req is nil, as the resources are
+// resolved before any message is received, so assert the type of req with
+// the comma-ok form.
+//
+// If the ResourceFunc fails because of invalid input data or a missing
+// resource, return a gRPC status error, e.g. status.Error(codes.InvalidArgument, ...).
+// Other errors result in a codes.Internal error.
+type ResourceFunc func(ctx context.Context, req any) (*common.Origin, error)
+
+// NilResourceFunc represents the Zero Value ResourceFunc.
+var NilResourceFunc = func(context.Context, any) (*common.Origin, error) {
+	return nil, nil
+}
+
+// Option configures the interceptors.
+type Option func(*options)
+
+type options struct {
+	keyProvider jwk.KeyProvider
+	authorizer  Authorizer
+}
+
+// WithKeyProvider makes the interceptors validate tokens using keys from
+// provider instead of the key sets configured globally in the jwk package.
+func WithKeyProvider(provider jwk.KeyProvider) Option {
+	return func(o *options) {
+		o.keyProvider = provider
+	}
+}
+
+// WithAuthorizer sets the Authorizer used for the authorizations added with
+// MethodConfig.Authorize.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = authorizer
+	}
+}
+
+// Security holds the security configuration of the methods of a gRPC
+// server.
+type Security struct {
+	options options
+
+	lock    sync.RWMutex
+	methods map[string]*MethodConfig
+}
+
+// New creates a Security without any method configurations.
+func New(opts ...Option) *Security {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Security{
+		options: o,
+		methods: map[string]*MethodConfig{},
+	}
+}
+
+// MethodConfig represents how to authenticate and authorize a given method.
+type MethodConfig struct {
+	public         bool
+	authorizations []authorizationConfig
+}
+
+type authorizationConfig struct {
+	action       string
+	resourceFunc ResourceFunc
+}
+
+// HandleSecureMethod creates a new MethodConfig for the full method name,
+// e.g. "/package.Service/Method", replacing any previous configuration of
+// the method.
+func (s *Security) HandleSecureMethod(fullMethod string) *MethodConfig {
+	m := &MethodConfig{}
+
+	s.lock.Lock()
+	s.methods[fullMethod] = m
+	s.lock.Unlock()
+
+	return m
+}
+
+// Public marks the method as not requiring authentication.
+func (m *MethodConfig) Public() *MethodConfig {
+	m.public = true
+	return m
+}
+
+// Authorize adds an Authorization Configuration to the MethodConfig. All
+// authorizations are required.
+func (m *MethodConfig) Authorize(action string, resourceFunc ResourceFunc) *MethodConfig {
+	m.authorizations = append(m.authorizations, authorizationConfig{action, resourceFunc})
+	return m
+}
+
+func (s *Security) lookup(fullMethod string) MethodConfig {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if m, found := s.methods[fullMethod]; found {
+		return *m
+	}
+
+	return MethodConfig{}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor that
+// authenticates and authorizes the call.
+func (s *Security) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		newCtx, err := s.check(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(newCtx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor that
+// authenticates and authorizes the call. The ResourceFuncs are called with a
+// nil request message.
+func (s *Security) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, err := s.check(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: stream, ctx: newCtx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Security) check(ctx context.Context, fullMethod string, req any) (context.Context, error) {
+	config := s.lookup(fullMethod)
+	if config.public {
+		return ctx, nil
+	}
+
+	authenticatedCtx, err := s.authenticate(ctx)
+	if err != nil {
+		log.WithTracing(ctx).
+			WithError(err).
+			WithField("method", fullMethod).
+			Debug("Call is not Authenticated")
+
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	if err := s.authorize(authenticatedCtx, fullMethod, req, config.authorizations); err != nil {
+		return nil, err
+	}
+
+	return authenticatedCtx, nil
+}
+
+func (s *Security) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get(MetadataAuthorization)
+	if len(values) == 0 || values[0] == "" {
+		return nil, errors.New("authorization metadata was empty")
+	}
+
+	rawToken := values[0]
+	if len(rawToken) > len(bearerPrefix) && strings.EqualFold(rawToken[:len(bearerPrefix)], bearerPrefix) {
+		rawToken = rawToken[len(bearerPrefix):]
+	}
+
+	var parseOpts []jwt.ParseOption
+	if s.options.keyProvider != nil {
+		parseOpts = append(parseOpts, jwt.WithKeyProvider(s.options.keyProvider))
+	}
+
+	token, err := jwt.Parse(rawToken, parseOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("authorization token not valid: %w", err)
+	}
+
+	claims := token.GetClaims()
+	if claims.TokenUse != jwt.TokenUseID && claims.TokenUse != jwt.TokenUseAccess {
+		return nil, fmt.Errorf("invalid token use %s", claims.TokenUse)
+	}
+
+	return claimscontext.NewContext(ctx, claims, rawToken), nil
+}
+
+func (s *Security) authorize(ctx context.Context, fullMethod string, req any, authorizations []authorizationConfig) error {
+	if len(authorizations) == 0 {
+		return nil
+	}
+
+	if s.options.authorizer == nil {
+		return status.Error(codes.Internal, "no authorizer configured")
+	}
+
+	userID, _ := useridcontext.FromContext(ctx)
+
+	for _, authorizeConfig := range authorizations {
+		resource, err := authorizeConfig.resourceFunc(ctx, req)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+
+			return status.Error(codes.Internal, "internal error")
+		}
+
+		ok, err := s.options.authorizer.IsAuthorizedWithContext(ctx, userID, authorizeConfig.action, resource)
+		if err != nil {
+			if status.Code(err) == codes.Canceled {
+				return status.Error(codes.Canceled, "canceled")
+			}
+
+			log.WithTracing(ctx).
+				WithError(err).
+				WithField("method", fullMethod).
+				Error("Failed to authorize call")
+
+			return status.Error(codes.Internal, "internal error")
+		}
+
+		if !ok {
+			log.WithTracing(ctx).
+				WithUserID(ctx).
+				WithField("method", fullMethod).
+				WithField("action", authorizeConfig.action).
+				WithField("resource", resource).
+				Debug("User is not Authorized")
+
+			return status.Error(codes.PermissionDenied, "permission denied")
+		}
+	}
+
+	return nil
+}
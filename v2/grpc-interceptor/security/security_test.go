@@ -0,0 +1,192 @@
+package security_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/SKF/proto/v2/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/claimscontext"
+	"github.com/SKF/go-utility/v2/grpc-interceptor/security"
+	"github.com/SKF/go-utility/v2/jwk"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+const (
+	getAsset     = "/assets.Assets/GetAsset"
+	checkHealth  = "/grpc.health.v1.Health/Check"
+	streamAssets = "/assets.Assets/StreamAssets"
+)
+
+type getAssetInput struct {
+	ID string
+}
+
+type recordingAuthorizer struct {
+	allowed   bool
+	err       error
+	userID    string
+	action    string
+	resources []*common.Origin
+}
+
+func (a *recordingAuthorizer) IsAuthorizedWithContext(_ context.Context, userID, action string, resource *common.Origin) (bool, error) {
+	a.userID, a.action = userID, action
+	a.resources = append(a.resources, resource)
+
+	return a.allowed, a.err
+}
+
+func assetResource(_ context.Context, req any) (*common.Origin, error) {
+	input, ok := req.(*getAssetInput)
+	if !ok || input.ID == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing asset id")
+	}
+
+	return &common.Origin{Id: input.ID, Type: "asset"}, nil
+}
+
+func newSecurity(t *testing.T, authorizer security.Authorizer) (*security.Security, *authtest.Server) {
+	t.Helper()
+
+	idp := authtest.NewServer(t)
+
+	sec := security.New(
+		security.WithKeyProvider(jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)),
+		security.WithAuthorizer(authorizer),
+	)
+	sec.HandleSecureMethod(checkHealth).Public()
+	sec.HandleSecureMethod(getAsset).Authorize("asset/read", assetResource)
+	sec.HandleSecureMethod(streamAssets).Authorize("asset/list", security.NilResourceFunc)
+
+	return sec, idp
+}
+
+func incomingContext(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(security.MetadataAuthorization, token))
+}
+
+func callUnary(sec *security.Security, ctx context.Context, method string, req any) (context.Context, error) {
+	var handlerCtx context.Context
+
+	_, err := sec.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ any) (any, error) {
+		handlerCtx = ctx
+		return nil, nil
+	})
+
+	return handlerCtx, err
+}
+
+func Test_UnaryServerInterceptor(t *testing.T) {
+	authorizer := &recordingAuthorizer{allowed: true}
+	sec, idp := newSecurity(t, authorizer)
+
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+
+	for _, header := range []string{token, "Bearer " + token} {
+		ctx, err := callUnary(sec, incomingContext(header), getAsset, &getAssetInput{ID: "asset-1"})
+		require.NoError(t, err)
+
+		userID, ok := useridcontext.FromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "user", userID)
+
+		claims, ok := claimscontext.FromContext(ctx)
+		require.True(t, ok)
+		assert.Equal(t, "a.b@example.com", claims.Username)
+
+		rawToken, _ := claimscontext.RawTokenFromContext(ctx)
+		assert.Equal(t, token, rawToken)
+	}
+
+	assert.Equal(t, "user", authorizer.userID)
+	assert.Equal(t, "asset/read", authorizer.action)
+	assert.Equal(t, &common.Origin{Id: "asset-1", Type: "asset"}, authorizer.resources[0])
+}
+
+func Test_UnaryServerInterceptorErrors(t *testing.T) {
+	idp := authtest.NewServer(t)
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+
+	for _, test := range []struct {
+		name       string
+		authorizer *recordingAuthorizer
+		token      string
+		method     string
+		req        any
+		code       codes.Code
+	}{
+		{name: "public method without token", authorizer: &recordingAuthorizer{}, method: checkHealth, code: codes.OK},
+		{name: "missing token", authorizer: &recordingAuthorizer{allowed: true}, method: getAsset, code: codes.Unauthenticated},
+		{name: "invalid token", authorizer: &recordingAuthorizer{allowed: true}, token: "invalid", method: getAsset, code: codes.Unauthenticated},
+		{name: "unconfigured method requires token", authorizer: &recordingAuthorizer{}, method: "/assets.Assets/DeleteAsset", code: codes.Unauthenticated},
+		{name: "unconfigured method with token", authorizer: &recordingAuthorizer{}, token: token, method: "/assets.Assets/DeleteAsset", code: codes.OK},
+		{name: "denied", authorizer: &recordingAuthorizer{}, token: token, method: getAsset, req: &getAssetInput{ID: "asset-1"}, code: codes.PermissionDenied},
+		{name: "authorizer error", authorizer: &recordingAuthorizer{err: errors.New("unavailable")}, token: token, method: getAsset, req: &getAssetInput{ID: "asset-1"}, code: codes.Internal},
+		{name: "resource status error", authorizer: &recordingAuthorizer{allowed: true}, token: token, method: getAsset, req: &getAssetInput{}, code: codes.InvalidArgument},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sec := security.New(
+				security.WithKeyProvider(jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)),
+				security.WithAuthorizer(test.authorizer),
+			)
+			sec.HandleSecureMethod(checkHealth).Public()
+			sec.HandleSecureMethod(getAsset).Authorize("asset/read", assetResource)
+
+			_, err := callUnary(sec, incomingContext(test.token), test.method, test.req)
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func Test_StreamServerInterceptor(t *testing.T) {
+	authorizer := &recordingAuthorizer{allowed: true}
+	sec, idp := newSecurity(t, authorizer)
+
+	token := idp.IDToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+
+	var handlerCtx context.Context
+
+	handler := func(_ any, stream grpc.ServerStream) error {
+		handlerCtx = stream.Context()
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: streamAssets, IsServerStream: true}
+
+	err := sec.StreamServerInterceptor()(nil, &serverStream{ctx: incomingContext(token)}, info, handler)
+	require.NoError(t, err)
+
+	userID, _ := useridcontext.FromContext(handlerCtx)
+	assert.Equal(t, "user", userID)
+	assert.Equal(t, "asset/list", authorizer.action)
+
+	err = sec.StreamServerInterceptor()(nil, &serverStream{ctx: incomingContext("")}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The ResourceFuncs get a nil request message.
+	info = &grpc.StreamServerInfo{FullMethod: getAsset, IsServerStream: true}
+
+	err = sec.StreamServerInterceptor()(nil, &serverStream{ctx: incomingContext(token)}, info, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
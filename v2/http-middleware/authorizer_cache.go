@@ -0,0 +1,141 @@
+package httpmiddleware
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SKF/proto/v2/common"
+
+	"github.com/SKF/go-utility/v2/cache"
+)
+
+// cachingAuthorizerFuncName is the function name of the cache keys of a
+// CachingAuthorizer, which the per function Cache metrics are reported for.
+const cachingAuthorizerFuncName = "IsAuthorizedWithContext"
+
+// decisionCache is implemented by Authorizers caching their decisions.
+// AuthorizeMiddleware uses it to deny a request on a cached denial without
+// calling the Authorizer for the other configured authorizations, looking
+// up each authorization in the cache once.
+type decisionCache interface {
+	CachedDecision(userID, action string, resource *common.Origin) (allowed, found bool)
+	// authorize asks the wrapped Authorizer without looking up the cache,
+	// and caches its decision.
+	authorize(ctx context.Context, userID, action string, resource *common.Origin) (bool, error)
+}
+
+var _ decisionCache = (*CachingAuthorizer)(nil)
+
+// CachingAuthorizer is an Authorizer caching the decisions of another
+// Authorizer. Allowed and denied decisions are cached for different times,
+// errors aren't cached.
+//
+// The hits and misses are counted by the Cache, see Cache.FuncHits and
+// Cache.FuncGets with the function name "IsAuthorizedWithContext".
+type CachingAuthorizer struct {
+	authorizer Authorizer
+	cache      *cache.Cache
+	allowTTL   time.Duration
+	denyTTL    time.Duration
+
+	// Invalidation is done by changing the generations in the cache keys,
+	// since the cache can't delete keys by prefix.
+	lock            sync.RWMutex
+	generation      uint64
+	userGenerations map[string]uint64
+}
+
+var _ Authorizer = (*CachingAuthorizer)(nil)
+
+// NewCachingAuthorizer creates a CachingAuthorizer caching the decisions of
+// authorizer in c. Allowed decisions are cached for allowTTL and denied
+// decisions for denyTTL, a non-positive TTL disables caching of those
+// decisions.
+func NewCachingAuthorizer(authorizer Authorizer, c *cache.Cache, allowTTL, denyTTL time.Duration) *CachingAuthorizer {
+	return &CachingAuthorizer{
+		authorizer:      authorizer,
+		cache:           c,
+		allowTTL:        allowTTL,
+		denyTTL:         denyTTL,
+		userGenerations: map[string]uint64{},
+	}
+}
+
+// IsAuthorizedWithContext returns the cached decision, or asks the wrapped
+// Authorizer and caches its decision.
+func (a *CachingAuthorizer) IsAuthorizedWithContext(ctx context.Context, userID, action string, resource *common.Origin) (bool, error) {
+	if allowed, found := a.CachedDecision(userID, action, resource); found {
+		return allowed, nil
+	}
+
+	return a.authorize(ctx, userID, action, resource)
+}
+
+func (a *CachingAuthorizer) authorize(ctx context.Context, userID, action string, resource *common.Origin) (bool, error) {
+	// The key is taken before asking, so a decision isn't cached past an
+	// invalidation made meanwhile.
+	key := a.key(userID, action, resource)
+
+	allowed, err := a.authorizer.IsAuthorizedWithContext(ctx, userID, action, resource)
+	if err != nil {
+		return false, err
+	}
+
+	ttl := a.allowTTL
+	if !allowed {
+		ttl = a.denyTTL
+	}
+
+	a.cache.SetWithTTL(key, allowed, ttl)
+
+	return allowed, nil
+}
+
+// CachedDecision returns the cached decision, if any. It counts as a get in
+// the Cache metrics, like IsAuthorizedWithContext.
+func (a *CachingAuthorizer) CachedDecision(userID, action string, resource *common.Origin) (allowed, found bool) {
+	value, found := a.cache.Get(a.key(userID, action, resource))
+	if !found {
+		return false, false
+	}
+
+	return value.(bool), true
+}
+
+// Invalidate removes the cached decision of the user's action on resource.
+func (a *CachingAuthorizer) Invalidate(userID, action string, resource *common.Origin) {
+	a.cache.Delete(a.key(userID, action, resource))
+}
+
+// InvalidateUser removes the cached decisions of the user, e.g. when the
+// user's roles have changed.
+func (a *CachingAuthorizer) InvalidateUser(userID string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.userGenerations[userID]++
+}
+
+// InvalidateAll removes all cached decisions.
+func (a *CachingAuthorizer) InvalidateAll() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.generation++
+	a.userGenerations = map[string]uint64{}
+}
+
+func (a *CachingAuthorizer) key(userID, action string, resource *common.Origin) cache.ObjectKey {
+	a.lock.RLock()
+	generation := strconv.FormatUint(a.generation, 10) + "." + strconv.FormatUint(a.userGenerations[userID], 10)
+	a.lock.RUnlock()
+
+	var resourceID, resourceType string
+	if resource != nil {
+		resourceID, resourceType = resource.Id, resource.Type
+	}
+
+	return cache.Key(cachingAuthorizerFuncName, generation, userID, action, resourceID, resourceType)
+}
@@ -0,0 +1,169 @@
+package httpmiddleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SKF/proto/v2/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/cache"
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	"github.com/SKF/go-utility/v2/jwk"
+)
+
+// cacheSettle is the time the cache needs to make a set value visible.
+const cacheSettle = 10 * time.Millisecond
+
+type decisionAuthorizer struct {
+	lock      sync.Mutex
+	decisions map[string]bool
+	err       error
+	calls     map[string]int
+}
+
+func (a *decisionAuthorizer) IsAuthorizedWithContext(_ context.Context, _, action string, _ *common.Origin) (bool, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.calls[action]++
+
+	return a.decisions[action], a.err
+}
+
+func (a *decisionAuthorizer) callCount(action string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.calls[action]
+}
+
+func newCachingAuthorizer(t *testing.T, decisions map[string]bool) (*httpmiddleware.CachingAuthorizer, *decisionAuthorizer, *cache.Cache) {
+	t.Helper()
+
+	c, err := cache.New(time.Minute, 10)
+	require.NoError(t, err)
+
+	authorizer := &decisionAuthorizer{decisions: decisions, calls: map[string]int{}}
+
+	return httpmiddleware.NewCachingAuthorizer(authorizer, c, time.Minute, time.Minute), authorizer, c
+}
+
+func Test_CachingAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	resource := &common.Origin{Id: "asset", Type: "asset"}
+
+	cachingAuthorizer, authorizer, c := newCachingAuthorizer(t, map[string]bool{"read": true})
+
+	for _, action := range []string{"read", "write"} {
+		allowed, err := cachingAuthorizer.IsAuthorizedWithContext(ctx, "user", action, resource)
+		require.NoError(t, err)
+		assert.Equal(t, action == "read", allowed)
+	}
+
+	time.Sleep(cacheSettle)
+
+	for _, action := range []string{"read", "write"} {
+		allowed, err := cachingAuthorizer.IsAuthorizedWithContext(ctx, "user", action, resource)
+		require.NoError(t, err)
+		assert.Equal(t, action == "read", allowed)
+		assert.Equal(t, 1, authorizer.callCount(action))
+	}
+
+	assert.Equal(t, uint64(4), c.FuncGets("IsAuthorizedWithContext"))
+	assert.Equal(t, uint64(2), c.FuncHits("IsAuthorizedWithContext"))
+
+	// Another resource isn't cached.
+	_, err := cachingAuthorizer.IsAuthorizedWithContext(ctx, "user", "read", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, authorizer.callCount("read"))
+}
+
+func Test_CachingAuthorizerInvalidation(t *testing.T) {
+	ctx := context.Background()
+	resource := &common.Origin{Id: "asset", Type: "asset"}
+
+	cachingAuthorizer, authorizer, _ := newCachingAuthorizer(t, map[string]bool{"read": true})
+
+	invalidations := []func(){
+		func() { cachingAuthorizer.Invalidate("user", "read", resource) },
+		func() { cachingAuthorizer.InvalidateUser("user") },
+		cachingAuthorizer.InvalidateAll,
+	}
+
+	for i, invalidate := range invalidations {
+		_, err := cachingAuthorizer.IsAuthorizedWithContext(ctx, "user", "read", resource)
+		require.NoError(t, err)
+		time.Sleep(cacheSettle)
+
+		invalidate()
+
+		_, err = cachingAuthorizer.IsAuthorizedWithContext(ctx, "user", "read", resource)
+		require.NoError(t, err)
+		time.Sleep(cacheSettle)
+
+		assert.Equal(t, i+2, authorizer.callCount("read"))
+	}
+}
+
+func Test_CachingAuthorizerDoesNotCacheErrors(t *testing.T) {
+	cachingAuthorizer, authorizer, _ := newCachingAuthorizer(t, nil)
+	authorizer.err = errors.New("unavailable")
+
+	for range 2 {
+		_, err := cachingAuthorizer.IsAuthorizedWithContext(context.Background(), "user", "read", nil)
+		require.Error(t, err)
+		time.Sleep(cacheSettle)
+	}
+
+	assert.Equal(t, 2, authorizer.callCount("read"))
+}
+
+func Test_AuthorizeMiddlewareShortCircuitsCachedDenial(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	var resourceCalls atomic.Int32
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/assets/{id}").Methods(http.MethodGet).AccessToken().
+		Authorize("admin", httpmiddleware.NilResourceFunc).
+		Authorize("read", func(*http.Request) (*common.Origin, error) {
+			resourceCalls.Add(1)
+			return nil, nil
+		})
+
+	cachingAuthorizer, authorizer, c := newCachingAuthorizer(t, map[string]bool{"read": true})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/assets/{id}", okHandler).Methods(http.MethodGet)
+	r.Use(
+		httpmiddleware.AuthenticateMiddlewareV3(
+			httpmiddleware.WithKeyProvider(provider),
+			httpmiddleware.WithSecurityRegistry(registry),
+		),
+		httpmiddleware.AuthorizeMiddleware(cachingAuthorizer, httpmiddleware.WithSecurityRegistry(registry)),
+	)
+
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+
+	_, err := cachingAuthorizer.IsAuthorizedWithContext(context.Background(), "user", "admin", nil)
+	require.NoError(t, err)
+	time.Sleep(cacheSettle)
+
+	// The cached admin denial decides the outcome, the resource of the read
+	// authorization isn't resolved and the authorizer isn't asked.
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodGet, "/assets/1", token))
+	assert.Equal(t, uint64(2), c.FuncGets("IsAuthorizedWithContext"))
+	assert.Equal(t, int32(0), resourceCalls.Load())
+	assert.Equal(t, 0, authorizer.callCount("read"))
+	assert.Equal(t, 1, authorizer.callCount("admin"))
+}
@@ -0,0 +1,185 @@
+package httpmiddleware
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+	http_server "github.com/SKF/go-utility/v2/http-server"
+	"github.com/SKF/go-utility/v2/log"
+)
+
+// CompressionMiddleware compresses responses with the encoding negotiated
+// from the Accept-Encoding header, like http_server.WriteJSONResponse does.
+// Responses without a body, already encoded, partial or smaller than
+// http_server.CompressionMinBodySize are left as they are.
+func CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http_server.AddVary(w.Header(), http_model.HeaderAcceptEncoding)
+
+		encoding := http_server.NegotiateEncoding(r.Header.Get(http_model.HeaderAcceptEncoding))
+		if encoding == http_server.EncodingIdentity || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+
+		if err := cw.close(); err != nil {
+			log.WithTracing(r.Context()).WithError(err).Error("Failed to write compressed response")
+		}
+	})
+}
+
+// compressResponseWriter buffers the body until it's larger than
+// http_server.CompressionMinBodySize, or the handler is done, to decide
+// whether to compress it.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	code    int
+	buf     []byte
+	started bool
+	// writer is set once started if the body is compressed.
+	writer io.WriteCloser
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if w.code != 0 {
+		return
+	}
+
+	w.code = code
+
+	if !compressible(code, w.Header()) {
+		w.start(false) //nolint: errcheck
+		return
+	}
+
+	// The size of the body is known up front when Content-Length is set.
+	if length, err := strconv.Atoi(w.Header().Get(http_model.HeaderContentLength)); err == nil {
+		w.start(length > http_server.CompressionMinBodySize) //nolint: errcheck
+	}
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.started {
+		if w.writer != nil {
+			return w.writer.Write(p)
+		}
+
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+
+	if len(w.buf) > http_server.CompressionMinBodySize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush starts compressing a streamed body right away.
+func (w *compressResponseWriter) Flush() {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.started {
+		w.start(true) //nolint: errcheck
+	}
+
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		flusher.Flush() //nolint: errcheck
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for
+// http.ResponseController.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start writes the header and the buffered body, compressing the body if
+// compress is set.
+func (w *compressResponseWriter) start(compress bool) error {
+	w.started = true
+
+	// Sniff the content type before it's compressed.
+	if w.Header().Get(http_model.HeaderContentType) == "" && len(w.buf) > 0 {
+		w.Header().Set(http_model.HeaderContentType, http.DetectContentType(w.buf))
+	}
+
+	if compress {
+		writer, err := http_server.NewCompressWriter(w.ResponseWriter, w.encoding)
+		if err != nil {
+			return err
+		}
+
+		w.writer = writer
+		w.Header().Set(http_model.HeaderContentEncoding, w.encoding)
+		w.Header().Del(http_model.HeaderContentLength)
+	}
+
+	w.ResponseWriter.WriteHeader(w.code)
+
+	buf := w.buf
+	w.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if w.writer != nil {
+		_, err := w.writer.Write(buf)
+		return err
+	}
+
+	_, err := w.ResponseWriter.Write(buf)
+
+	return err
+}
+
+// close writes the body left in the buffer and finishes the compression.
+func (w *compressResponseWriter) close() error {
+	if w.code == 0 {
+		return nil
+	}
+
+	if !w.started {
+		return w.start(false)
+	}
+
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+
+	return nil
+}
+
+// compressible returns whether a response may be compressed. Range
+// responses aren't, since their ranges are of the uncompressed body.
+func compressible(code int, header http.Header) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified &&
+		code != http.StatusPartialContent && header.Get(http_model.HeaderContentRange) == "" &&
+		header.Get(http_model.HeaderContentEncoding) == ""
+}
@@ -0,0 +1,143 @@
+package httpmiddleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	http_server "github.com/SKF/go-utility/v2/http-server"
+)
+
+func gunzip(t *testing.T, body io.Reader) string {
+	t.Helper()
+
+	r, err := gzip.NewReader(body)
+	require.NoError(t, err)
+
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(decompressed)
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("<p>compressible</p>", http_server.CompressionMinBodySize)
+
+	for _, test := range []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		compressed     bool
+		expected       string
+	}{
+		{
+			name:           "large body written in chunks",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				for i := 0; i < len(large); i += 100 {
+					w.Write([]byte(large[i:min(i+100, len(large))])) //nolint: errcheck
+				}
+			},
+			compressed: true,
+			expected:   large,
+		},
+		{
+			name:           "small body",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte("small")) //nolint: errcheck
+			},
+			expected: "small",
+		},
+		{
+			name:           "not accepted",
+			acceptEncoding: "gzip;q=0",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte(large)) //nolint: errcheck
+			},
+			expected: large,
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Encoding", "custom")
+				w.Write([]byte(large)) //nolint: errcheck
+			},
+			expected: large,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			resp := httptest.NewRecorder()
+			httpmiddleware.CompressionMiddleware(test.handler).ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"))
+
+			if test.compressed {
+				// The content type is sniffed before compressing.
+				assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+				assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+				assert.Equal(t, test.expected, gunzip(t, resp.Body))
+			} else {
+				assert.NotEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+				assert.Equal(t, test.expected, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareWithWriteJSONResponse(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"a": 1}`), http_server.CompressionMinBodySize)
+
+	handler := httpmiddleware.CompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http_server.WriteJSONResponse(context.Background(), w, r, http.StatusCreated, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	// The body is only compressed once.
+	require.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, []string{"Accept-Encoding"}, resp.Header().Values("Vary"))
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, string(body), gunzip(t, resp.Body))
+}
+
+func TestCompressionMiddlewareSkipsRanges(t *testing.T) {
+	content := strings.Repeat("<p>compressible</p>", http_server.CompressionMinBodySize)
+
+	handler := httpmiddleware.CompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "index.html", time.Time{}, strings.NewReader(content))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-1999")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	// The range is of the uncompressed content.
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes 0-1999/"+strconv.Itoa(len(content)), resp.Header().Get("Content-Range"))
+	assert.Equal(t, content[:2000], resp.Body.String())
+}
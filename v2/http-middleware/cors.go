@@ -1,11 +1,23 @@
 package httpmiddleware
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+	"github.com/SKF/go-utility/v2/trace"
 )
 
 // CorsMiddleware adds Access-Control-Allow-Origin header to responses.
+//
+// Deprecated: Any origin is reflected, allowing every site to call the API.
+// Use CORS instead.
 func CorsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
@@ -14,6 +26,9 @@ func CorsMiddleware(next http.Handler) http.Handler {
 }
 
 // Options takes a list of methods and headers and returns an Options HandlerFunc
+//
+// Deprecated: Use CORS instead, which answers the preflight requests of
+// every route.
 func Options(methods, headers []string) http.HandlerFunc {
 	methodsJoined := strings.Join(methods, ", ")
 	headersJoined := strings.Join(headers, ", ")
@@ -23,3 +38,309 @@ func Options(methods, headers []string) http.HandlerFunc {
 		w.Header().Set("Access-Control-Allow-Headers", headersJoined)
 	}
 }
+
+// DefaultCORSHeaders returns the request headers allowed by default,
+// Authorization, Content-Type, X-Client-ID and the tracing headers.
+func DefaultCORSHeaders() []string {
+	return append(
+		[]string{http_model.HeaderAuthorization, http_model.HeaderContentType, http_model.HeaderClientID},
+		trace.AllHeaders()...,
+	)
+}
+
+// CORS configures Cross-Origin Resource Sharing.
+//
+// An origin is either exact, e.g. "https://app.example.com", or a pattern
+// with a single wildcard, e.g. "https://*.example.com" or
+// "http://localhost:*", where the wildcard matches letters, digits, dots and
+// dashes. The origin "*" allows every origin.
+type CORS struct {
+	// Stage selects the StageOrigins to allow.
+	Stage string
+	// AllowedOrigins are allowed in every stage.
+	AllowedOrigins []string
+	// StageOrigins are the origins allowed per stage, in addition to
+	// AllowedOrigins.
+	StageOrigins map[string][]string
+
+	// AllowedMethods defaults to the methods of the routes matching the
+	// path of the request. When set, only the methods of the routes in
+	// AllowedMethods are allowed.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed, it defaults to
+	// DefaultCORSHeaders. "*" allows every header.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers readable by the client.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies and Authorization. It
+	// can't be combined with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long the result of a preflight request may be cached.
+	// It isn't sent when zero, and browsers then cache it for 5 seconds.
+	MaxAge time.Duration
+}
+
+type corsHandler struct {
+	router *mux.Router
+
+	allowAllOrigins bool
+	origins         []originPattern
+	methods         map[string]bool
+	allowAllHeaders bool
+	headers         map[string]bool
+	allowHeaders    string
+	exposeHeaders   string
+	credentials     bool
+	maxAge          string
+}
+
+// originPattern matches the origins starting with prefix and ending with
+// suffix. Without a wildcard they must equal prefix.
+type originPattern struct {
+	prefix   string
+	suffix   string
+	wildcard bool
+}
+
+// Handler returns a handler answering the preflight requests of the routes
+// of router, and adding the CORS headers to the responses of router.
+// Preflight requests for paths without routes are left to router.
+//
+// It panics if an origin pattern has more than one wildcard, or if every
+// origin is allowed together with credentials, which would let any site make
+// authenticated requests.
+func (c CORS) Handler(router *mux.Router) http.Handler {
+	h := &corsHandler{
+		router:        router,
+		exposeHeaders: strings.Join(c.ExposedHeaders, ", "),
+		credentials:   c.AllowCredentials,
+	}
+
+	if c.MaxAge > 0 {
+		h.maxAge = strconv.Itoa(int(c.MaxAge.Seconds()))
+	}
+
+	for _, origin := range append(slices.Clone(c.AllowedOrigins), c.StageOrigins[c.Stage]...) {
+		if origin == "*" {
+			h.allowAllOrigins = true
+			continue
+		}
+
+		h.origins = append(h.origins, newOriginPattern(origin))
+	}
+
+	if h.allowAllOrigins && h.credentials {
+		panic("CORS can't allow credentials from every origin")
+	}
+
+	if c.AllowedMethods != nil {
+		h.methods = map[string]bool{}
+		for _, method := range c.AllowedMethods {
+			h.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	headers := c.AllowedHeaders
+	if headers == nil {
+		headers = DefaultCORSHeaders()
+	}
+
+	h.headers = map[string]bool{}
+
+	for _, header := range headers {
+		if header == "*" {
+			h.allowAllHeaders = true
+			continue
+		}
+
+		h.headers[strings.ToLower(header)] = true
+	}
+
+	h.allowHeaders = strings.Join(headers, ", ")
+
+	return h
+}
+
+func newOriginPattern(origin string) originPattern {
+	origin = strings.ToLower(origin)
+
+	prefix, suffix, wildcard := strings.Cut(origin, "*")
+	if strings.Contains(suffix, "*") {
+		panic(fmt.Sprintf("CORS origin pattern %q has more than one wildcard", origin))
+	}
+
+	return originPattern{prefix: prefix, suffix: suffix, wildcard: wildcard}
+}
+
+func (p originPattern) match(origin string) bool {
+	if !p.wildcard {
+		return origin == p.prefix
+	}
+
+	if len(origin) <= len(p.prefix)+len(p.suffix) ||
+		!strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+
+	for _, r := range origin[len(p.prefix) : len(origin)-len(p.suffix)] {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *corsHandler) allowedOrigin(origin string) bool {
+	if h.allowAllOrigins {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range h.origins {
+		if pattern.match(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get(http_model.HeaderOrigin)
+
+	// The response depends on the origin, unless every origin gets the
+	// same wildcard.
+	if !h.allowAllOrigins {
+		w.Header().Add(http_model.HeaderVary, http_model.HeaderOrigin)
+	}
+
+	if origin == "" || !h.allowedOrigin(origin) {
+		h.router.ServeHTTP(w, req)
+		return
+	}
+
+	if req.Method == http.MethodOptions && req.Header.Get(http_model.HeaderAccessControlRequestMethod) != "" {
+		h.preflight(w, req, origin)
+		return
+	}
+
+	h.writeOriginHeaders(w, origin)
+
+	if h.exposeHeaders != "" {
+		w.Header().Set(http_model.HeaderAccessControlExposeHeaders, h.exposeHeaders)
+	}
+
+	h.router.ServeHTTP(w, req)
+}
+
+func (h *corsHandler) writeOriginHeaders(w http.ResponseWriter, origin string) {
+	if h.allowAllOrigins {
+		w.Header().Set(http_model.HeaderAccessControlAllowOrigin, "*")
+	} else {
+		w.Header().Set(http_model.HeaderAccessControlAllowOrigin, origin)
+	}
+
+	if h.credentials {
+		w.Header().Set(http_model.HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// preflight answers a preflight request. A request for a method or headers
+// which aren't allowed gets no CORS headers, which makes the browser reject
+// the actual request.
+func (h *corsHandler) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	w.Header().Add(http_model.HeaderVary, http_model.HeaderAccessControlRequestMethod)
+	w.Header().Add(http_model.HeaderVary, http_model.HeaderAccessControlRequestHeaders)
+
+	requestMethod := strings.ToUpper(req.Header.Get(http_model.HeaderAccessControlRequestMethod))
+
+	methods := h.routeMethods(req, requestMethod)
+	if len(methods) == 0 {
+		h.router.ServeHTTP(w, req)
+		return
+	}
+
+	requestHeaders := splitHeaderList(req.Header.Get(http_model.HeaderAccessControlRequestHeaders))
+
+	if slices.Contains(methods, requestMethod) && h.allowedHeaders(requestHeaders) {
+		h.writeOriginHeaders(w, origin)
+		w.Header().Set(http_model.HeaderAccessControlAllowMethods, strings.Join(methods, ", "))
+
+		if h.allowAllHeaders {
+			w.Header().Set(http_model.HeaderAccessControlAllowHeaders, strings.Join(requestHeaders, ", "))
+		} else {
+			w.Header().Set(http_model.HeaderAccessControlAllowHeaders, h.allowHeaders)
+		}
+
+		if h.maxAge != "" {
+			w.Header().Set(http_model.HeaderAccessControlMaxAge, h.maxAge)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeMethods returns the allowed methods of the routes matching the path
+// of req. Routes without methods match requestMethod.
+func (h *corsHandler) routeMethods(req *http.Request, requestMethod string) []string {
+	candidates := []string{requestMethod}
+
+	h.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error { //nolint: errcheck
+		if methods, err := route.GetMethods(); err == nil {
+			for _, method := range methods {
+				if !slices.Contains(candidates, method) {
+					candidates = append(candidates, method)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	var methods []string
+
+	for _, method := range candidates {
+		if method == http.MethodOptions || h.methods != nil && !h.methods[method] {
+			continue
+		}
+
+		routeReq := req.Clone(req.Context())
+		routeReq.Method = method
+
+		var match mux.RouteMatch
+		if h.router.Match(routeReq, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+func (h *corsHandler) allowedHeaders(headers []string) bool {
+	if h.allowAllHeaders {
+		return true
+	}
+
+	for _, header := range headers {
+		if !h.headers[strings.ToLower(header)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitHeaderList(list string) []string {
+	var headers []string
+
+	for _, header := range strings.Split(list, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,161 @@
+package httpmiddleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	"github.com/SKF/go-utility/v2/stages"
+)
+
+func corsRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/reports/{id}", ok).Methods(http.MethodGet, http.MethodDelete)
+	router.HandleFunc("/reports", ok).Methods(http.MethodPost)
+
+	return router
+}
+
+var testCORS = httpmiddleware.CORS{
+	Stage:          stages.StageSandbox,
+	AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+	StageOrigins: map[string][]string{
+		stages.StageSandbox: {"http://localhost:*"},
+		stages.StageProd:    {"https://prod.example.net"},
+	},
+	ExposedHeaders:   []string{"RateLimit-Remaining"},
+	AllowCredentials: true,
+	MaxAge:           10 * time.Minute,
+}
+
+func TestCORSOrigins(t *testing.T) {
+	handler := testCORS.Handler(corsRouter())
+
+	for _, test := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://evil.com", false},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://.example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"http://localhost:3000", true},
+		{"https://prod.example.net", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+		req.Header.Set("Origin", test.origin)
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, []string{"Origin"}, resp.Header().Values("Vary"))
+
+		if test.allowed {
+			assert.Equal(t, test.origin, resp.Header().Get("Access-Control-Allow-Origin"), test.origin)
+			assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, "RateLimit-Remaining", resp.Header().Get("Access-Control-Expose-Headers"))
+		} else {
+			assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"), test.origin)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := testCORS.Handler(corsRouter())
+
+	preflight := func(path, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	resp := preflight("/reports/1", http.MethodDelete, "authorization, x-client-id, x-datadog-trace-id")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE, GET", resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, resp.Header().Get("Access-Control-Allow-Headers"), "X-Client-ID")
+	assert.Contains(t, resp.Header().Get("Access-Control-Allow-Headers"), "X-B3-TraceId")
+	assert.Equal(t, "600", resp.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	resp = preflight("/reports", http.MethodPost, "")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "POST", resp.Header().Get("Access-Control-Allow-Methods"))
+
+	// A method of another route isn't allowed.
+	resp = preflight("/reports", http.MethodGet, "")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = preflight("/reports/1", http.MethodGet, "X-Unknown")
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = preflight("/unknown", http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	handler := httpmiddleware.CORS{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{"*"},
+	}.Handler(corsRouter())
+
+	req := httptest.NewRequest(http.MethodOptions, "/reports/1", nil)
+	req.Header.Set("Origin", "https://anywhere.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET", resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Custom", resp.Header().Get("Access-Control-Allow-Headers"))
+	assert.NotContains(t, resp.Header().Values("Vary"), "Origin")
+	assert.Empty(t, resp.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCORSInvalidPattern(t *testing.T) {
+	assert.Panics(t, func() {
+		httpmiddleware.CORS{AllowedOrigins: []string{"https://*.*.example.com"}}.Handler(corsRouter())
+	})
+}
+
+func TestCORSAllowAllWithCredentials(t *testing.T) {
+	assert.Panics(t, func() {
+		httpmiddleware.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Handler(corsRouter())
+	})
+
+	// Every origin of a stage is as permissive.
+	assert.Panics(t, func() {
+		httpmiddleware.CORS{
+			Stage:            stages.StageSandbox,
+			StageOrigins:     map[string][]string{stages.StageSandbox: {"*"}},
+			AllowCredentials: true,
+		}.Handler(corsRouter())
+	})
+}
@@ -17,13 +17,6 @@
 //	    )).
 //	    Methods(http.MethodPost)
 //
-//	router.
-//	    HandleFunc(pathToCreateUser, http_middleware.Options(
-//	        []string{http.MethodPost},
-//	        []string{http_model.HeaderContentType},
-//	    )).
-//	    Methods(http.MethodOptions)
-//
 //	http_middleware.
 //	    HandleSecureEndpoint(pathToCreateCompanyUser).
 //	    Methods(http.MethodPost).
@@ -34,9 +27,20 @@
 //	router.Use(
 //	    // Middleware is run from top to bottom, order is important
 //	    http_middleware.TrailingSlashMiddleware,
-//	    http_middleware.CorsMiddleware,
 //	    http_middleware.OpenCensusMiddleware,
 //	    http_middleware.AuthenticateMiddleware("<jwkeyset_url>"),
 //	    http_middleware.AuthorizeMiddleware(authorizerClient),
 //	)
+//
+//	// CORS wraps the router to answer the preflight requests of every route
+//	cors := http_middleware.CORS{
+//	    Stage:          stage,
+//	    AllowedOrigins: []string{"https://app.example.com"},
+//	    StageOrigins: map[string][]string{
+//	        stages.StageSandbox: {"http://localhost:*"},
+//	    },
+//	    MaxAge: time.Hour,
+//	}
+//
+//	http.ListenAndServe(":8080", cors.Handler(router))
 package httpmiddleware
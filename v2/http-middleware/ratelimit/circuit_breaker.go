@@ -0,0 +1,325 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeout      = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned by the connections of a CircuitBreaker without
+// a fallback while the circuit is open.
+var ErrCircuitOpen = errors.New("rate limit backend circuit is open")
+
+// CircuitBreakerConfig configures a CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit, it defaults to DefaultFailureThreshold.
+	FailureThreshold int
+	// OpenTimeout is the time the circuit stays open before a call is let
+	// through to probe the backend, it defaults to DefaultOpenTimeout.
+	OpenTimeout time.Duration
+	// Fallback is used while the circuit is open and when a call to the
+	// backend fails, if set. Typically a MemoryPool.
+	Fallback ConnectionPool
+	// Instances is the number of instances sharing the limits. The limits
+	// are divided by Instances when using the Fallback, to approximate the
+	// global limits with local counters. It defaults to 1.
+	Instances int
+}
+
+// CircuitBreaker is a ConnectionPool skipping the backend pool while it's
+// unhealthy, so requests don't have to wait for timeouts of an unreachable
+// backend.
+//
+// The circuit opens after FailureThreshold consecutive failures. While it's
+// open the Fallback is used, or the calls fail with ErrCircuitOpen. After
+// OpenTimeout a single call is let through to the backend, closing the
+// circuit if it succeeds and opening it again if it fails.
+type CircuitBreaker struct {
+	pool   ConnectionPool
+	config CircuitBreakerConfig
+	now    func() time.Time
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+	// slots are the semaphore slots held through the breaker, and whether
+	// they are held on the fallback, so they are released where they were
+	// acquired.
+	slots map[heldSlot]bool
+}
+
+type heldSlot struct {
+	key, token string
+}
+
+var (
+	_ ConnectionPool      = (*CircuitBreaker)(nil)
+	_ BatchConnection     = (*circuitBreakerConnection)(nil)
+	_ SemaphoreConnection = (*circuitBreakerConnection)(nil)
+)
+
+// NewCircuitBreaker creates a CircuitBreaker around pool.
+func NewCircuitBreaker(pool ConnectionPool, config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultOpenTimeout
+	}
+
+	if config.Instances <= 0 {
+		config.Instances = 1
+	}
+
+	return &CircuitBreaker{
+		pool:   pool,
+		config: config,
+		now:    time.Now,
+		slots:  map[heldSlot]bool{},
+	}
+}
+
+// Open returns whether the circuit is open.
+func (b *CircuitBreaker) Open() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.failures >= b.config.FailureThreshold
+}
+
+func (b *CircuitBreaker) Connect() Connection {
+	return &circuitBreakerConnection{breaker: b}
+}
+
+// allow returns whether a call may be made to the backend, and whether the
+// call is a probe.
+func (b *CircuitBreaker) allow() (allowed, probe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.config.FailureThreshold {
+		return true, false
+	}
+
+	if b.probing || b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+		return false, false
+	}
+
+	b.probing = true
+
+	return true, true
+}
+
+func (b *CircuitBreaker) record(err error, probe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= b.config.FailureThreshold {
+		b.openedAt = b.now()
+	}
+}
+
+func (b *CircuitBreaker) hold(slot heldSlot, onFallback bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.slots[slot] = onFallback
+}
+
+// unhold forgets slot, and returns whether it was held on the fallback.
+func (b *CircuitBreaker) unhold(slot heldSlot) (onFallback, found bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	onFallback, found = b.slots[slot]
+	delete(b.slots, slot)
+
+	return onFallback, found
+}
+
+type circuitBreakerConnection struct {
+	breaker  *CircuitBreaker
+	backend  Connection
+	fallback Connection
+}
+
+// call runs fn on the backend connection if the circuit allows it, and
+// otherwise, or if it fails, on the fallback connection.
+func (c *circuitBreakerConnection) call(fn func(Connection) error) error {
+	err := ErrCircuitOpen
+
+	if allowed, probe := c.breaker.allow(); allowed {
+		if c.backend == nil {
+			c.backend = c.breaker.pool.Connect()
+		}
+
+		err = fn(c.backend)
+		c.breaker.record(err, probe)
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	if c.breaker.config.Fallback == nil {
+		return err
+	}
+
+	if c.fallback == nil {
+		c.fallback = c.breaker.config.Fallback.Connect()
+	}
+
+	return fn(c.fallbackConnection())
+}
+
+func (c *circuitBreakerConnection) fallbackConnection() Connection {
+	return &scaledConnection{Connection: c.fallback, instances: c.breaker.config.Instances}
+}
+
+func (c *circuitBreakerConnection) Incr(key string, expiry time.Duration) (count int, ttl time.Duration, err error) {
+	err = c.call(func(conn Connection) (err error) {
+		count, ttl, err = conn.Incr(key, expiry)
+		return err
+	})
+
+	return count, ttl, err
+}
+
+func (c *circuitBreakerConnection) Take(limit Limit, now time.Time) (result Result, err error) {
+	err = c.call(func(conn Connection) (err error) {
+		result, err = conn.Take(limit, now)
+		return err
+	})
+
+	return result, err
+}
+
+// TakeAll keeps the limits checked in a single call when the backend is a
+// BatchConnection.
+func (c *circuitBreakerConnection) TakeAll(limits []Limit, now time.Time) (results []Result, err error) {
+	err = c.call(func(conn Connection) (err error) {
+		results, err = takeAll(conn, limits, now)
+		return err
+	})
+
+	return results, err
+}
+
+// Acquire holds the slot on the fallback connection while the circuit is
+// open. The connection holding the slot is recorded, so it's released there.
+func (c *circuitBreakerConnection) Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (acquired bool, err error) {
+	onFallback := false
+
+	err = c.call(func(conn Connection) (err error) {
+		onFallback = conn != c.backend
+		acquired, err = acquire(conn, key, token, limit, expiry, now)
+
+		return err
+	})
+
+	if err == nil && acquired {
+		c.breaker.hold(heldSlot{key, token}, onFallback)
+	}
+
+	return acquired, err
+}
+
+// Release releases the slot on the connection it was acquired on. A slot
+// held by the backend while the circuit is open expires there.
+func (c *circuitBreakerConnection) Release(key, token string) error {
+	onFallback, found := c.breaker.unhold(heldSlot{key, token})
+
+	switch {
+	case !found:
+		return c.call(func(conn Connection) error {
+			return release(conn, key, token)
+		})
+	case onFallback:
+		if c.fallback == nil {
+			c.fallback = c.breaker.config.Fallback.Connect()
+		}
+
+		return release(c.fallbackConnection(), key, token)
+	}
+
+	allowed, probe := c.breaker.allow()
+	if !allowed {
+		return ErrCircuitOpen
+	}
+
+	if c.backend == nil {
+		c.backend = c.breaker.pool.Connect()
+	}
+
+	err := release(c.backend, key, token)
+	c.breaker.record(err, probe)
+
+	return err
+}
+
+// scaledConnection divides the limits by the number of instances sharing
+// them, assuming each instance gets an equal share of the requests.
+type scaledConnection struct {
+	Connection
+	instances int
+}
+
+func (c *scaledConnection) Incr(key string, expiry time.Duration) (int, time.Duration, error) {
+	count, ttl, err := c.Connection.Incr(key, expiry)
+	return count * c.instances, ttl, err
+}
+
+func (c *scaledConnection) Take(limit Limit, now time.Time) (Result, error) {
+	local := limit
+	local.Requests = int(math.Ceil(float64(limit.Requests) / float64(c.instances)))
+	local.Burst = int(math.Ceil(float64(limit.Burst) / float64(c.instances)))
+
+	result, err := c.Connection.Take(local, now)
+	result.Limit *= c.instances
+	result.Remaining *= c.instances
+
+	return result, err
+}
+
+func (c *scaledConnection) Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (bool, error) {
+	local := int(math.Ceil(float64(limit) / float64(c.instances)))
+	return acquire(c.Connection, key, token, local, expiry, now)
+}
+
+func (c *scaledConnection) Release(key, token string) error {
+	return release(c.Connection, key, token)
+}
+
+func (c *circuitBreakerConnection) Close() error {
+	var errs []error
+
+	if c.backend != nil {
+		errs = append(errs, c.backend.Close())
+	}
+
+	if c.fallback != nil {
+		errs = append(errs, c.fallback.Close())
+	}
+
+	return errors.Join(errs...)
+}
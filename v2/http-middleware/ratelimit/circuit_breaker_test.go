@@ -0,0 +1,209 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	poolMock := &ConnectionPoolMock{}
+	connMock := &ConnectionMock{}
+	poolMock.On("Connect").Return(connMock)
+	connMock.On("Close").Return(nil)
+
+	breaker := ratelimit.NewCircuitBreaker(poolMock, ratelimit.CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+	})
+
+	incr := func() error {
+		conn := breaker.Connect()
+		defer conn.Close()
+
+		_, _, err := conn.Incr("key", time.Minute)
+
+		return err
+	}
+
+	connMock.On("Incr", "key", time.Minute).Return(0, time.Duration(0), errUnreachable).Twice()
+
+	require.ErrorIs(t, incr(), errUnreachable)
+	assert.False(t, breaker.Open())
+	require.ErrorIs(t, incr(), errUnreachable)
+	assert.True(t, breaker.Open())
+
+	// The backend is skipped while the circuit is open.
+	require.ErrorIs(t, incr(), ratelimit.ErrCircuitOpen)
+	connMock.AssertNumberOfCalls(t, "Incr", 2)
+
+	// A failing probe opens the circuit again.
+	time.Sleep(30 * time.Millisecond)
+	connMock.On("Incr", "key", time.Minute).Return(0, time.Duration(0), errUnreachable).Once()
+	require.ErrorIs(t, incr(), errUnreachable)
+	require.ErrorIs(t, incr(), ratelimit.ErrCircuitOpen)
+
+	// A successful probe closes the circuit.
+	time.Sleep(30 * time.Millisecond)
+	connMock.On("Incr", "key", time.Minute).Return(1, time.Minute, nil)
+	require.NoError(t, incr())
+	assert.False(t, breaker.Open())
+	require.NoError(t, incr())
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	poolMock := &ConnectionPoolMock{}
+	connMock := &ConnectionMock{}
+	poolMock.On("Connect").Return(connMock)
+	connMock.On("Close").Return(nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(0, time.Duration(0), errUnreachable)
+
+	breaker := ratelimit.NewCircuitBreaker(poolMock, ratelimit.CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Hour,
+		Fallback:         ratelimit.NewMemoryPool(100),
+		Instances:        2,
+	})
+
+	limiter := &ratelimit.Limiter{}
+	limiter.SetConnectionPool(breaker)
+	limiter.Configure(
+		ratelimit.Request{Method: http.MethodGet, PathTemplate: "/apa"},
+		func(req *http.Request) ([]ratelimit.Limit, error) {
+			return []ratelimit.Limit{{Key: req.URL.Path, Requests: 4}}, nil
+		},
+	)
+
+	req, r := getRouterAndRequest(t)
+	r.Use(limiter.Middleware())
+
+	// The local counters allow half of the limit with two instances.
+	for _, code := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		require.Equal(t, code, resp.Code)
+	}
+
+	connMock.AssertNumberOfCalls(t, "Incr", 1)
+	assert.True(t, breaker.Open())
+}
+
+func TestCircuitBreakerFallbackScalesTake(t *testing.T) {
+	poolMock := &ConnectionPoolMock{}
+	connMock := &ConnectionMock{}
+	poolMock.On("Connect").Return(connMock)
+	connMock.On("Close").Return(nil)
+	connMock.On("Take", mock.Anything, mock.Anything).Return(ratelimit.Result{}, errUnreachable)
+
+	breaker := ratelimit.NewCircuitBreaker(poolMock, ratelimit.CircuitBreakerConfig{
+		Fallback:  ratelimit.NewMemoryPool(100),
+		Instances: 3,
+	})
+
+	conn := breaker.Connect()
+	defer conn.Close()
+
+	limit := ratelimit.Limit{Key: "bucket", Requests: 6, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 6}
+
+	result, err := conn.Take(limit, time.Unix(1000, 0))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 6, result.Limit)
+	assert.Equal(t, 3, result.Remaining)
+}
+
+// flakyPool is a ConnectionPool failing while failing is set.
+type flakyPool struct {
+	ratelimit.ConnectionPool
+	failing atomic.Bool
+}
+
+func (p *flakyPool) Connect() ratelimit.Connection {
+	return &flakyConnection{p.ConnectionPool.Connect().(ratelimit.SemaphoreConnection), p}
+}
+
+type flakyConnection struct {
+	ratelimit.SemaphoreConnection
+	pool *flakyPool
+}
+
+func (c *flakyConnection) Incr(key string, expiry time.Duration) (int, time.Duration, error) {
+	if c.pool.failing.Load() {
+		return 0, 0, errUnreachable
+	}
+
+	return c.SemaphoreConnection.Incr(key, expiry)
+}
+
+func (c *flakyConnection) Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (bool, error) {
+	if c.pool.failing.Load() {
+		return false, errUnreachable
+	}
+
+	return c.SemaphoreConnection.Acquire(key, token, limit, expiry, now)
+}
+
+func (c *flakyConnection) Release(key, token string) error {
+	if c.pool.failing.Load() {
+		return errUnreachable
+	}
+
+	return c.SemaphoreConnection.Release(key, token)
+}
+
+func TestCircuitBreakerReleasesWhereAcquired(t *testing.T) {
+	backend := &flakyPool{ConnectionPool: ratelimit.NewMemoryPool(100)}
+	fallback := ratelimit.NewMemoryPool(100)
+
+	breaker := ratelimit.NewCircuitBreaker(backend, ratelimit.CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenTimeout:      10 * time.Millisecond,
+		Fallback:         fallback,
+	})
+
+	acquire := func(pool ratelimit.ConnectionPool, token string) bool {
+		conn := pool.Connect()
+		defer conn.Close()
+
+		acquired, err := conn.(ratelimit.SemaphoreConnection).Acquire("key", token, 1, time.Minute, time.Now())
+		require.NoError(t, err)
+
+		return acquired
+	}
+
+	release := func(pool ratelimit.ConnectionPool, token string) {
+		conn := pool.Connect()
+		defer conn.Close()
+
+		require.NoError(t, conn.(ratelimit.SemaphoreConnection).Release("key", token))
+	}
+
+	// A slot acquired on the fallback is released there after the circuit
+	// closed.
+	backend.failing.Store(true)
+	require.True(t, acquire(breaker, "a"))
+	assert.True(t, breaker.Open())
+
+	backend.failing.Store(false)
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, acquire(breaker, "b"))
+	assert.False(t, breaker.Open())
+
+	release(breaker, "a")
+	assert.True(t, acquire(fallback, "c"))
+
+	// A slot acquired on the backend is released there.
+	release(breaker, "b")
+	assert.True(t, acquire(backend, "d"))
+}
@@ -0,0 +1,412 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+	http_server "github.com/SKF/go-utility/v2/http-server"
+	"github.com/SKF/go-utility/v2/log"
+	"github.com/SKF/go-utility/v2/uuid"
+
+	"github.com/gorilla/mux"
+	"go.opencensus.io/trace"
+)
+
+// DefaultSlotExpiry is the time after which the slot of a request is freed
+// if it isn't released, e.g. because the instance handling the request died.
+const DefaultSlotExpiry = 5 * time.Minute
+
+const (
+	minAcquireInterval = 10 * time.Millisecond
+	maxAcquireInterval = 200 * time.Millisecond
+
+	// slotRenewals is the number of times a slot is renewed per expiry.
+	slotRenewals = 3
+	// minSlotExpiry bounds how often slots are renewed, shorter expiries are
+	// raised to it.
+	minSlotExpiry = slotRenewals * 10 * time.Millisecond
+)
+
+// The keys of the semaphores are prefixed by their kind, so a
+// ConcurrencyLimit key can't collide with the key of a route.
+const (
+	keySemaphorePrefix   = "key:"
+	routeSemaphorePrefix = "route:"
+)
+
+// ErrSemaphoreUnsupported is returned when the connections of the
+// ConnectionPool of a ConcurrencyLimiter aren't SemaphoreConnections.
+var ErrSemaphoreUnsupported = errors.New("connection doesn't support semaphores")
+
+// SemaphoreConnection is a Connection able to hold the slots of a
+// semaphore, to limit the number of requests in flight across instances.
+type SemaphoreConnection interface {
+	Connection
+
+	// Acquire holds a slot of the semaphore at key for token, unless limit
+	// slots are already held, and returns whether the slot was acquired.
+	// The slot expires after expiry unless it's released. Acquiring a slot
+	// already held by token extends its expiry. The check and the update
+	// must be atomic.
+	Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (bool, error)
+
+	// Release releases the slot of the semaphore at key held by token.
+	Release(key, token string) error
+}
+
+func acquire(db Connection, key, token string, limit int, expiry time.Duration, now time.Time) (bool, error) {
+	semaphore, ok := db.(SemaphoreConnection)
+	if !ok {
+		return false, ErrSemaphoreUnsupported
+	}
+
+	return semaphore.Acquire(key, token, limit, expiry, now)
+}
+
+func release(db Connection, key, token string) error {
+	semaphore, ok := db.(SemaphoreConnection)
+	if !ok {
+		return ErrSemaphoreUnsupported
+	}
+
+	return semaphore.Release(key, token)
+}
+
+// ConcurrencyLimit limits the number of requests with the same Key being
+// handled at the same time.
+type ConcurrencyLimit struct {
+	Key string
+	// InFlight is the number of requests allowed in flight.
+	InFlight int
+}
+
+// ConcurrencyLimiter limits the number of requests in flight, per key and
+// per route. A request waiting for a slot is queued for the queue timeout
+// of its route before being rejected.
+type ConcurrencyLimiter struct {
+	connectionPool ConnectionPool
+
+	lock    sync.RWMutex
+	configs map[Request]concurrencyRoute
+}
+
+type concurrencyLimitGenerator func(*http.Request) ([]ConcurrencyLimit, error)
+
+type concurrencyRoute struct {
+	routeConfig
+	limits concurrencyLimitGenerator
+}
+
+// slot is a slot of a semaphore to acquire before handling a request, and
+// the status of the response when it can't be acquired.
+type slot struct {
+	key    string
+	limit  int
+	status int
+	body   []byte
+}
+
+// WithMaxInFlight limits the number of requests in flight on the route,
+// regardless of their keys. Requests over the limit are rejected with 503
+// Service Unavailable. It's only used by the ConcurrencyLimiter.
+func WithMaxInFlight(requests int) RouteOption {
+	return func(c *routeConfig) {
+		c.maxInFlight = requests
+	}
+}
+
+// WithQueueTimeout sets how long a request waits for a slot before being
+// rejected. By default requests are rejected right away. It's only used by
+// the ConcurrencyLimiter.
+func WithQueueTimeout(timeout time.Duration) RouteOption {
+	return func(c *routeConfig) {
+		c.queueTimeout = timeout
+	}
+}
+
+// WithSlotExpiry sets the time after which the slot of a request is freed if
+// it isn't released, it defaults to DefaultSlotExpiry, which is also used
+// when expiry isn't positive, and expiries under 30ms are raised to 30ms. The
+// slots are renewed every third of the expiry while the request is handled,
+// so the expiry only bounds how long the slots of an instance that died stay
+// held. It's only used by the ConcurrencyLimiter.
+func WithSlotExpiry(expiry time.Duration) RouteOption {
+	return func(c *routeConfig) {
+		c.slotExpiry = expiry
+	}
+}
+
+// SetConnectionPool sets the pool of the semaphores. Its connections must be
+// SemaphoreConnections, as the connections of the Redis, memory and circuit
+// breaker pools are.
+func (l *ConcurrencyLimiter) SetConnectionPool(p ConnectionPool) *ConcurrencyLimiter {
+	l.connectionPool = p
+	return l
+}
+
+// Configure limits the requests in flight on path. gen returns the limits
+// of a request, with the keys they are counted by, and may be nil when only
+// WithMaxInFlight is used.
+//
+// A request is handled once it holds a slot of every limit.
+func (l *ConcurrencyLimiter) Configure(path Request, gen concurrencyLimitGenerator, opts ...RouteOption) {
+	config := concurrencyRoute{
+		routeConfig: routeConfig{slotExpiry: DefaultSlotExpiry},
+		limits:      gen,
+	}
+
+	for _, opt := range opts {
+		opt(&config.routeConfig)
+	}
+
+	if config.slotExpiry <= 0 {
+		config.slotExpiry = DefaultSlotExpiry
+	}
+
+	config.slotExpiry = max(config.slotExpiry, minSlotExpiry)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.configs == nil {
+		l.configs = map[Request]concurrencyRoute{}
+	}
+
+	l.configs[path] = config
+}
+
+// Concurrency limiting middleware. Requests over a ConcurrencyLimit are
+// rejected with 429 Too Many Requests, and requests over the limit of
+// WithMaxInFlight with 503 Service Unavailable.
+//
+// The key will be stored in clear text in the cache. If the key contains personal data please consider hashing the key
+func (l *ConcurrencyLimiter) Middleware() mux.MiddlewareFunc {
+	if l.connectionPool == nil {
+		panic("connectionPool is not configured")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, span := trace.StartSpan(req.Context(), "ConcurrencyLimitMiddleware/Handler")
+
+			pathTemplate, err := mux.CurrentRoute(req).GetPathTemplate()
+			if err != nil {
+				log.WithTracing(ctx).WithError(err).Errorf("failed to parse mux path template from request: %s", req.URL.Path)
+				span.End()
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			route := Request{Method: req.Method, PathTemplate: pathTemplate}
+
+			l.lock.RLock()
+			config, ok := l.configs[route]
+			l.lock.RUnlock()
+
+			if !ok {
+				span.End()
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			slots, err := config.slots(req, route)
+			if err != nil {
+				log.WithTracing(ctx).WithError(err).Error("Failed to generate concurrency limits")
+				span.End()
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			token := uuid.New().String()
+
+			rejected, err := l.acquireAll(ctx, slots, token, config.routeConfig)
+			span.End()
+
+			if err != nil {
+				log.WithTracing(ctx).WithError(err).Errorf("failed to acquire concurrency slot")
+
+				if config.failurePolicy == FailClosed {
+					http_server.WriteJSONResponse(ctx, w, req, http.StatusServiceUnavailable, http_model.ErrResponseServiceUnavailable)
+					return
+				}
+
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			if rejected != nil {
+				http_server.WriteJSONResponse(ctx, w, req, rejected.status, rejected.body)
+				return
+			}
+
+			defer l.releaseAll(ctx, slots, token)
+			defer l.renewAll(ctx, slots, token, config.slotExpiry)()
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// slots returns the slots to acquire for req, the slots of the keys first
+// so a request waiting for its key doesn't hold a slot of the route.
+func (c concurrencyRoute) slots(req *http.Request, route Request) ([]slot, error) {
+	var slots []slot
+
+	if c.limits != nil {
+		limits, err := c.limits(req)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, limit := range limits {
+			slots = append(slots, slot{
+				key:    keySemaphorePrefix + limit.Key,
+				limit:  limit.InFlight,
+				status: http.StatusTooManyRequests,
+				body:   http_model.ErrResponseTooManyRequests,
+			})
+		}
+	}
+
+	if c.maxInFlight > 0 {
+		slots = append(slots, slot{
+			key:    routeSemaphorePrefix + route.Method + " " + route.PathTemplate,
+			limit:  c.maxInFlight,
+			status: http.StatusServiceUnavailable,
+			body:   http_model.ErrResponseServiceUnavailable,
+		})
+	}
+
+	return slots, nil
+}
+
+// acquireAll acquires every slot for token, waiting at most the queue
+// timeout of config, and returns the slot which couldn't be acquired. The
+// acquired slots are released when a slot can't be acquired.
+func (l *ConcurrencyLimiter) acquireAll(ctx context.Context, slots []slot, token string, config routeConfig) (*slot, error) {
+	ctx, span := trace.StartSpan(ctx, "ConcurrencyLimitMiddleware/acquireAll")
+	defer span.End()
+
+	if len(slots) == 0 {
+		return nil, nil
+	}
+
+	db := l.connectionPool.Connect()
+	defer db.Close()
+
+	deadline := time.Now().Add(config.queueTimeout)
+
+	for i, s := range slots {
+		acquired, err := waitForSlot(ctx, db, s, token, config.slotExpiry, deadline)
+		if err == nil && acquired {
+			continue
+		}
+
+		for _, held := range slots[:i] {
+			if releaseErr := release(db, held.key, token); releaseErr != nil {
+				log.WithTracing(ctx).WithError(releaseErr).Errorf("failed to release concurrency slot")
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &slots[i], nil
+	}
+
+	return nil, nil
+}
+
+// waitForSlot polls the semaphore of s, backing off exponentially, until
+// the slot is acquired, deadline is reached or ctx is done.
+func waitForSlot(ctx context.Context, db Connection, s slot, token string, expiry time.Duration, deadline time.Time) (bool, error) {
+	interval := minAcquireInterval
+
+	for {
+		acquired, err := acquire(db, s.key, token, s.limit, expiry, time.Now())
+		if err != nil || acquired {
+			return acquired, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+
+		timer := time.NewTimer(min(interval, remaining))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, nil
+		case <-timer.C:
+		}
+
+		interval = min(2*interval, maxAcquireInterval)
+	}
+}
+
+// renewAll renews the slots held by token every third of expiry, until the
+// returned func is called, so the slots of long requests don't expire while
+// they are handled.
+func (l *ConcurrencyLimiter) renewAll(ctx context.Context, slots []slot, token string, expiry time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(max(expiry, minSlotExpiry) / slotRenewals)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			l.renew(ctx, slots, token, expiry)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// renew acquires the slots held by token again, extending their expiry.
+func (l *ConcurrencyLimiter) renew(ctx context.Context, slots []slot, token string, expiry time.Duration) {
+	db := l.connectionPool.Connect()
+	defer db.Close()
+
+	for _, s := range slots {
+		acquired, err := acquire(db, s.key, token, s.limit, expiry, time.Now())
+		if err != nil {
+			log.WithTracing(ctx).WithError(err).Errorf("failed to renew concurrency slot")
+		} else if !acquired {
+			log.WithTracing(ctx).Warnf("concurrency slot of %s expired before the request was handled", s.key)
+		}
+	}
+}
+
+func (l *ConcurrencyLimiter) releaseAll(ctx context.Context, slots []slot, token string) {
+	db := l.connectionPool.Connect()
+	defer db.Close()
+
+	for _, s := range slots {
+		if err := release(db, s.key, token); err != nil {
+			log.WithTracing(ctx).WithError(err).Errorf("failed to release concurrency slot")
+		}
+	}
+}
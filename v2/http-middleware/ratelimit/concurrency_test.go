@@ -0,0 +1,271 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+)
+
+func TestMemoryPoolSemaphore(t *testing.T) {
+	conn := ratelimit.NewMemoryPool(100).Connect().(ratelimit.SemaphoreConnection)
+	now := time.Now()
+
+	acquire := func(token string, now time.Time) bool {
+		acquired, err := conn.Acquire("key", token, 2, time.Minute, now)
+		require.NoError(t, err)
+
+		return acquired
+	}
+
+	assert.True(t, acquire("a", now))
+	assert.True(t, acquire("b", now))
+	assert.False(t, acquire("c", now))
+	assert.True(t, acquire("a", now), "a slot is reacquired by its holder")
+
+	require.NoError(t, conn.Release("key", "b"))
+	assert.True(t, acquire("c", now))
+
+	// The slot of a expires a minute after it was last acquired.
+	assert.False(t, acquire("d", now.Add(59*time.Second)))
+	assert.True(t, acquire("d", now.Add(time.Minute)))
+}
+
+func TestMemoryPoolSemaphoreIsNotEvicted(t *testing.T) {
+	pool := ratelimit.NewMemoryPool(16)
+	conn := pool.Connect().(ratelimit.SemaphoreConnection)
+	now := time.Now()
+
+	acquired, err := conn.Acquire("key", "a", 1, time.Minute, now)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// Fill the pool with counters.
+	for i := range 100 {
+		_, _, err = conn.Incr(fmt.Sprintf("counter-%d", i), time.Minute)
+		require.NoError(t, err)
+	}
+
+	acquired, err = conn.Acquire("key", "b", 1, time.Minute, now)
+	require.NoError(t, err)
+	assert.False(t, acquired, "the slot of a is still held")
+}
+
+// blockingRouter returns a router handling GET /reports/{id}, blocking the
+// requests with the Block header until release is closed. The handler
+// reports on entered when a blocking request is being handled.
+func blockingRouter(limiter *ratelimit.ConcurrencyLimiter) (r *mux.Router, entered chan struct{}, release chan struct{}) {
+	entered = make(chan struct{})
+	release = make(chan struct{})
+
+	r = mux.NewRouter()
+	r.HandleFunc("/reports/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Block") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet)
+	r.Use(limiter.Middleware())
+
+	return r, entered, release
+}
+
+func serve(r http.Handler, user string, block bool) int {
+	req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+	req.Header.Set("User", user)
+
+	if block {
+		req.Header.Set("Block", "true")
+	}
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	return resp.Code
+}
+
+func perUser(req *http.Request) ([]ratelimit.ConcurrencyLimit, error) {
+	return []ratelimit.ConcurrencyLimit{{Key: "user:" + req.Header.Get("User"), InFlight: 1}}, nil
+}
+
+func TestConcurrencyLimitPerKey(t *testing.T) {
+	limiter := &ratelimit.ConcurrencyLimiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	limiter.Configure(ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"}, perUser)
+
+	r, entered, release := blockingRouter(limiter)
+
+	done := make(chan int)
+	go func() { done <- serve(r, "1", true) }()
+	<-entered
+
+	assert.Equal(t, http.StatusTooManyRequests, serve(r, "1", false))
+	assert.Equal(t, http.StatusOK, serve(r, "2", false))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	assert.Equal(t, http.StatusOK, serve(r, "1", false), "the slot is released")
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	limiter := &ratelimit.ConcurrencyLimiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	limiter.Configure(
+		ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+		perUser,
+		ratelimit.WithQueueTimeout(10*time.Second),
+	)
+
+	r, entered, release := blockingRouter(limiter)
+
+	done := make(chan int)
+	go func() { done <- serve(r, "1", true) }()
+	<-entered
+
+	queued := make(chan int)
+	go func() { queued <- serve(r, "1", false) }()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-queued)
+}
+
+func TestConcurrencyLimitMaxInFlight(t *testing.T) {
+	limiter := &ratelimit.ConcurrencyLimiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	limiter.Configure(
+		ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+		nil,
+		ratelimit.WithMaxInFlight(1),
+		ratelimit.WithQueueTimeout(20*time.Millisecond),
+	)
+
+	r, entered, release := blockingRouter(limiter)
+
+	done := make(chan int)
+	go func() { done <- serve(r, "1", true) }()
+	<-entered
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(r, "2", false))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestConcurrencyLimitKeysDontCollideWithRoutes(t *testing.T) {
+	limiter := &ratelimit.ConcurrencyLimiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	limiter.Configure(
+		ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+		func(req *http.Request) ([]ratelimit.ConcurrencyLimit, error) {
+			// The key of user 1 looks like the key of the route.
+			if req.Header.Get("User") == "1" {
+				return []ratelimit.ConcurrencyLimit{{Key: "route:GET /reports/{id}", InFlight: 1}}, nil
+			}
+
+			return perUser(req)
+		},
+		ratelimit.WithMaxInFlight(2),
+	)
+
+	r, entered, release := blockingRouter(limiter)
+
+	done := make(chan int)
+	go func() { done <- serve(r, "2", true) }()
+	<-entered
+
+	assert.Equal(t, http.StatusOK, serve(r, "1", false))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestConcurrencyLimitRenewsSlots(t *testing.T) {
+	limiter := &ratelimit.ConcurrencyLimiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	limiter.Configure(
+		ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+		perUser,
+		ratelimit.WithSlotExpiry(60*time.Millisecond),
+	)
+
+	r, entered, release := blockingRouter(limiter)
+
+	done := make(chan int)
+	go func() { done <- serve(r, "1", true) }()
+	<-entered
+
+	// The slot is still held after it would have expired.
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, serve(r, "1", false))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, serve(r, "1", false), "the slot is released")
+}
+
+func TestConcurrencyLimitShortSlotExpiry(t *testing.T) {
+	for _, expiry := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		limiter := &ratelimit.ConcurrencyLimiter{}
+		limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+		limiter.Configure(
+			ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+			perUser,
+			ratelimit.WithSlotExpiry(expiry),
+		)
+
+		r, entered, release := blockingRouter(limiter)
+
+		done := make(chan int)
+		go func() { done <- serve(r, "1", true) }()
+		<-entered
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, http.StatusTooManyRequests, serve(r, "1", false), expiry)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-done, expiry)
+	}
+}
+
+func TestConcurrencyLimitFailurePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy   ratelimit.FailurePolicy
+		expected int
+	}{
+		{ratelimit.FailOpen, http.StatusOK},
+		{ratelimit.FailClosed, http.StatusServiceUnavailable},
+	} {
+		poolMock := &ConnectionPoolMock{}
+		connMock := &ConnectionMock{}
+		poolMock.On("Connect").Return(connMock).Once()
+		connMock.On("Close").Return(nil).Once()
+
+		// The connections of the mock don't support semaphores.
+		limiter := &ratelimit.ConcurrencyLimiter{}
+		limiter.SetConnectionPool(poolMock)
+		limiter.Configure(
+			ratelimit.Request{Method: http.MethodGet, PathTemplate: "/reports/{id}"},
+			perUser,
+			ratelimit.WithFailurePolicy(test.policy),
+		)
+
+		r, _, _ := blockingRouter(limiter)
+
+		assert.Equal(t, test.expected, serve(r, "1", false))
+		poolMock.AssertExpectations(t)
+		connMock.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,235 @@
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+// HeaderForwardedFor is the header used by proxies to pass on the address of
+// the client.
+const HeaderForwardedFor = "X-Forwarded-For"
+
+// DefaultMaxBodySize is the size of the largest body BodyField reads.
+const DefaultMaxBodySize = 1 << 20
+
+// ErrKeyNotFound is returned by a KeyFunc when the request doesn't contain
+// the value of the key.
+var ErrKeyNotFound = errors.New("rate limit key not found in request")
+
+// ErrBodyTooLarge is returned by the KeyFunc of BodyField when the body is
+// larger than its max size. The middleware rejects the request with
+// http.StatusRequestEntityTooLarge.
+var ErrBodyTooLarge = errors.New("request body too large for rate limit key")
+
+// KeyFunc extracts a value from a request to use in a rate limit key.
+type KeyFunc func(*http.Request) (string, error)
+
+// ClientIP returns a KeyFunc extracting the address of the client. The
+// X-Forwarded-For header is only used when the request comes from one of
+// trustedProxies, and is then read from the right, skipping the addresses of
+// trustedProxies, since the client can set any leftmost addresses.
+func ClientIP(trustedProxies ...netip.Prefix) KeyFunc {
+	trusted := func(addr netip.Addr) bool {
+		for _, prefix := range trustedProxies {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(req *http.Request) (string, error) {
+		remote, err := parseAddr(req.RemoteAddr)
+		if err != nil {
+			return "", fmt.Errorf("invalid remote address %q: %w", req.RemoteAddr, err)
+		}
+
+		if !trusted(remote) {
+			return remote.String(), nil
+		}
+
+		forwarded := strings.Split(strings.Join(req.Header.Values(HeaderForwardedFor), ","), ",")
+
+		client := remote
+
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr, err := parseAddr(strings.TrimSpace(forwarded[i]))
+			if err != nil {
+				break
+			}
+
+			client = addr
+
+			if !trusted(addr) {
+				break
+			}
+		}
+
+		return client.String(), nil
+	}
+}
+
+func parseAddr(value string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap(), nil
+}
+
+// UserID returns a KeyFunc extracting the user ID stored by the
+// authentication middleware.
+func UserID() KeyFunc {
+	return func(req *http.Request) (string, error) {
+		userID, ok := useridcontext.FromContext(req.Context())
+		if !ok || userID == "" {
+			return "", ErrKeyNotFound
+		}
+
+		return userID, nil
+	}
+}
+
+// ClientID returns a KeyFunc extracting the X-Client-ID header.
+func ClientID() KeyFunc {
+	return Header(http_model.HeaderClientID)
+}
+
+// Header returns a KeyFunc extracting the header name.
+func Header(name string) KeyFunc {
+	return func(req *http.Request) (string, error) {
+		value := req.Header.Get(name)
+		if value == "" {
+			return "", ErrKeyNotFound
+		}
+
+		return value, nil
+	}
+}
+
+// PathVariable returns a KeyFunc extracting the mux path variable name.
+func PathVariable(name string) KeyFunc {
+	return func(req *http.Request) (string, error) {
+		value, found := mux.Vars(req)[name]
+		if !found {
+			return "", ErrKeyNotFound
+		}
+
+		return value, nil
+	}
+}
+
+// BodyField returns a KeyFunc extracting a field from a JSON body of at most
+// DefaultMaxBodySize bytes. Nested fields are separated by dots, e.g.
+// "user.email". The body is reset so it can be read again by the handler.
+func BodyField(field string) KeyFunc {
+	return BodyFieldWithMaxSize(field, DefaultMaxBodySize)
+}
+
+// BodyFieldWithMaxSize is BodyField reading at most maxSize bytes of the
+// body. A larger body fails with ErrBodyTooLarge.
+func BodyFieldWithMaxSize(field string, maxSize int64) KeyFunc {
+	path := strings.Split(field, ".")
+
+	return func(req *http.Request) (string, error) {
+		if req.Body == nil {
+			return "", ErrKeyNotFound
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxSize+1))
+		if err != nil {
+			return "", err
+		}
+
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+
+		if int64(len(body)) > maxSize {
+			return "", ErrBodyTooLarge
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return "", ErrKeyNotFound
+		}
+
+		for _, name := range path {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return "", ErrKeyNotFound
+			}
+
+			if value, ok = object[name]; !ok {
+				return "", ErrKeyNotFound
+			}
+		}
+
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case float64, bool:
+			return fmt.Sprint(value), nil
+		default:
+			return "", ErrKeyNotFound
+		}
+	}
+}
+
+// Compose returns a KeyFunc joining the values of keyFuncs with ":".
+func Compose(keyFuncs ...KeyFunc) KeyFunc {
+	return func(req *http.Request) (string, error) {
+		values := make([]string, 0, len(keyFuncs))
+
+		for _, keyFunc := range keyFuncs {
+			value, err := keyFunc(req)
+			if err != nil {
+				return "", err
+			}
+
+			values = append(values, value)
+		}
+
+		return strings.Join(values, ":"), nil
+	}
+}
+
+// Hashed returns a KeyFunc hashing the value of keyFunc, to avoid storing
+// personal data in clear text.
+func Hashed(keyFunc KeyFunc) KeyFunc {
+	return func(req *http.Request) (string, error) {
+		value, err := keyFunc(req)
+		if err != nil {
+			return "", err
+		}
+
+		return HashKey(value), nil
+	}
+}
+
+// HashKey returns the hex encoded SHA-256 hash of key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
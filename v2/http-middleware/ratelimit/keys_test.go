@@ -0,0 +1,108 @@
+package ratelimit_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+func TestClientIP(t *testing.T) {
+	keyFunc := ratelimit.ClientIP(netip.MustParsePrefix("10.0.0.0/8"))
+
+	for _, test := range []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+		{name: "untrusted proxy", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"198.51.100.1"}, expected: "192.0.2.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "spoofed address", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"}, expected: "198.51.100.1"},
+		{name: "several headers", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.1", "198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.2"}, expected: "10.0.0.2"},
+		{name: "IPv6", remoteAddr: "[2001:db8::1]:1234", expected: "2001:db8::1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+
+			for _, value := range test.forwardedFor {
+				req.Header.Add(ratelimit.HeaderForwardedFor, value)
+			}
+
+			key, err := keyFunc(req)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, key)
+		})
+	}
+}
+
+func TestBodyField(t *testing.T) {
+	body := `{"user": {"email": "a.b@example.com", "age": 42}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	key, err := ratelimit.BodyField("user.email")(req)
+	require.NoError(t, err)
+	assert.Equal(t, "a.b@example.com", key)
+
+	key, err = ratelimit.BodyField("user.age")(req)
+	require.NoError(t, err)
+	assert.Equal(t, "42", key)
+
+	_, err = ratelimit.BodyField("user.name")(req)
+	require.ErrorIs(t, err, ratelimit.ErrKeyNotFound)
+
+	read, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(read))
+}
+
+func TestBodyFieldMaxSize(t *testing.T) {
+	body := `{"user": {"email": "a.b@example.com"}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	_, err := ratelimit.BodyFieldWithMaxSize("user.email", 16)(req)
+	require.ErrorIs(t, err, ratelimit.ErrBodyTooLarge)
+
+	// The whole body can still be read by the handler.
+	read, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(read))
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	key, err := ratelimit.BodyFieldWithMaxSize("user.email", int64(len(body)))(req)
+	require.NoError(t, err)
+	assert.Equal(t, "a.b@example.com", key)
+}
+
+func TestComposeAndHash(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = req.WithContext(useridcontext.NewContext(req.Context(), "user"))
+
+	keyFunc := ratelimit.Compose(ratelimit.UserID(), ratelimit.PathVariable("id"))
+
+	key, err := keyFunc(req)
+	require.NoError(t, err)
+	assert.Equal(t, "user:1", key)
+
+	key, err = ratelimit.Hashed(keyFunc)(req)
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.HashKey("user:1"), key)
+	assert.Len(t, key, 64)
+
+	_, err = ratelimit.Compose(ratelimit.UserID(), ratelimit.ClientID())(req)
+	require.ErrorIs(t, err, ratelimit.ErrKeyNotFound)
+}
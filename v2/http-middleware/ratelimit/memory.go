@@ -0,0 +1,332 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const memoryShards = 16
+
+// MemoryPool is a ConnectionPool keeping the counters in process memory.
+// It's useful for local development, tests and services running a single
+// instance, and as a fallback when Redis is unreachable. The limits are not
+// shared between instances.
+//
+// The keys are spread over sharded maps, and the least recently used keys
+// are evicted when the pool is full. The semaphores of Acquire are kept
+// apart and never evicted while a slot is held.
+type MemoryPool struct {
+	shards [memoryShards]*memoryShard
+	now    func() time.Time
+}
+
+type memoryShard struct {
+	lock    sync.Mutex
+	maxKeys int
+	entries map[string]*list.Element
+	lru     *list.List
+	// semaphores maps the keys of the semaphores to the tokens holding a
+	// slot and the time their slot expires.
+	semaphores map[string]map[string]time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	expires time.Time
+
+	// count is used by FixedWindow and SlidingWindowCounter.
+	count int
+	// log is used by SlidingWindowLog.
+	log []time.Time
+	// tokens and counted are used by TokenBucket.
+	tokens  float64
+	counted time.Time
+}
+
+type memoryConnection struct {
+	pool *MemoryPool
+}
+
+var (
+	_ ConnectionPool      = (*MemoryPool)(nil)
+	_ SemaphoreConnection = (*memoryConnection)(nil)
+)
+
+// NewMemoryPool creates a MemoryPool keeping at most maxKeys keys.
+func NewMemoryPool(maxKeys int) *MemoryPool {
+	p := &MemoryPool{now: time.Now}
+
+	for i := range p.shards {
+		p.shards[i] = &memoryShard{
+			maxKeys:    max(1, maxKeys/memoryShards),
+			entries:    map[string]*list.Element{},
+			lru:        list.New(),
+			semaphores: map[string]map[string]time.Time{},
+		}
+	}
+
+	return p
+}
+
+func (p *MemoryPool) Connect() Connection {
+	return &memoryConnection{pool: p}
+}
+
+func (p *MemoryPool) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key)) //nolint: errcheck
+
+	return p.shards[h.Sum32()%memoryShards]
+}
+
+// get returns the entry at key, creating a new entry expiring at expires if
+// it's missing or expired. The shard must be locked.
+func (s *memoryShard) get(key string, now, expires time.Time) *memoryEntry {
+	if entry := s.peek(key, now); entry != nil {
+		s.lru.MoveToFront(s.entries[key])
+		return entry
+	}
+
+	entry := &memoryEntry{key: key, expires: expires}
+	s.entries[key] = s.lru.PushFront(entry)
+
+	for s.lru.Len() > s.maxKeys {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+
+	return entry
+}
+
+// peek returns the entry at key, or nil if it's missing or expired. The
+// shard must be locked.
+func (s *memoryShard) peek(key string, now time.Time) *memoryEntry {
+	element, found := s.entries[key]
+	if !found {
+		return nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !now.Before(entry.expires) {
+		s.lru.Remove(element)
+		delete(s.entries, key)
+
+		return nil
+	}
+
+	return entry
+}
+
+func (c *memoryConnection) Close() error {
+	return nil
+}
+
+func (c *memoryConnection) Incr(key string, expiry time.Duration) (int, time.Duration, error) {
+	now := c.pool.now()
+
+	shard := c.pool.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	entry := shard.get(key, now, now.Add(expiry))
+	entry.count++
+
+	return entry.count, entry.expires.Sub(now), nil
+}
+
+func (c *memoryConnection) Take(limit Limit, now time.Time) (Result, error) {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return Result{RetryAfter: limit.Window, Reset: limit.Window}, nil
+	}
+
+	// All entries of a limit are kept in the same shard.
+	shard := c.pool.shard(limit.Key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	switch limit.Algorithm {
+	case SlidingWindowLog:
+		return shard.slidingWindowLog(limit, now), nil
+	case SlidingWindowCounter:
+		return shard.slidingWindowCounter(limit, now), nil
+	case TokenBucket:
+		return shard.tokenBucket(limit, now), nil
+	default:
+		return Result{}, fmt.Errorf("unsupported algorithm %d", limit.Algorithm)
+	}
+}
+
+func (c *memoryConnection) Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+
+	shard := c.pool.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	slots, found := shard.semaphores[key]
+	if !found {
+		// The semaphores whose slots all expired are dropped when a new
+		// one is added.
+		if len(shard.semaphores) >= shard.maxKeys {
+			for semaphore, semaphoreSlots := range shard.semaphores {
+				if expireSlots(semaphoreSlots, now) == 0 {
+					delete(shard.semaphores, semaphore)
+				}
+			}
+		}
+
+		slots = map[string]time.Time{}
+		shard.semaphores[key] = slots
+	}
+
+	expireSlots(slots, now)
+
+	if _, held := slots[token]; !held && len(slots) >= limit {
+		return false, nil
+	}
+
+	slots[token] = now.Add(expiry)
+
+	return true, nil
+}
+
+func (c *memoryConnection) Release(key, token string) error {
+	shard := c.pool.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if slots, found := shard.semaphores[key]; found {
+		delete(slots, token)
+
+		if len(slots) == 0 {
+			delete(shard.semaphores, key)
+		}
+	}
+
+	return nil
+}
+
+// expireSlots removes the expired slots, and returns the number of slots
+// left.
+func expireSlots(slots map[string]time.Time, now time.Time) int {
+	for holder, expires := range slots {
+		if !now.Before(expires) {
+			delete(slots, holder)
+		}
+	}
+
+	return len(slots)
+}
+
+func (s *memoryShard) slidingWindowLog(limit Limit, now time.Time) Result {
+	entry := s.get(limit.Key+":log", now, now.Add(limit.Window))
+
+	start := now.Add(-limit.Window)
+	for len(entry.log) > 0 && !entry.log[0].After(start) {
+		entry.log = entry.log[1:]
+	}
+
+	result := Result{Limit: limit.Requests}
+
+	if len(entry.log) < limit.Requests {
+		entry.log = append(entry.log, now)
+		entry.expires = now.Add(limit.Window)
+		result.Allowed = true
+	}
+
+	result.Remaining = max(0, limit.Requests-len(entry.log))
+
+	if len(entry.log) > 0 {
+		result.Reset = entry.log[len(entry.log)-1].Add(limit.Window).Sub(now)
+
+		if !result.Allowed {
+			result.RetryAfter = entry.log[0].Add(limit.Window).Sub(now)
+		}
+	}
+
+	return result
+}
+
+func (s *memoryShard) slidingWindowCounter(limit Limit, now time.Time) Result {
+	window := now.UnixNano() / int64(limit.Window)
+	windowEnd := time.Unix(0, (window+1)*int64(limit.Window))
+	elapsed := limit.Window - windowEnd.Sub(now)
+
+	var previous int
+	if entry := s.peek(fmt.Sprintf("%s:%d", limit.Key, window-1), now); entry != nil {
+		previous = entry.count
+	}
+
+	current := s.get(fmt.Sprintf("%s:%d", limit.Key, window), now, windowEnd.Add(limit.Window))
+
+	weighted := float64(previous)*float64(limit.Window-elapsed)/float64(limit.Window) + float64(current.count)
+	result := Result{Limit: limit.Requests}
+
+	if weighted < float64(limit.Requests) {
+		current.count++
+		weighted++
+		result.Allowed = true
+	}
+
+	result.Remaining = max(0, int(math.Floor(float64(limit.Requests)-weighted)))
+
+	switch {
+	case current.count > 0:
+		result.Reset = 2*limit.Window - elapsed
+	case previous > 0:
+		result.Reset = limit.Window - elapsed
+	}
+
+	// The weight of the previous window decreases until the next window,
+	// where the current window becomes the previous one.
+	if !result.Allowed {
+		result.RetryAfter = limit.Window - elapsed
+
+		if current.count < limit.Requests && previous > 0 {
+			decay := time.Duration((weighted-float64(limit.Requests))*float64(limit.Window)/float64(previous)) + time.Millisecond
+			result.RetryAfter = min(result.RetryAfter, decay)
+		}
+	}
+
+	return result
+}
+
+func (s *memoryShard) tokenBucket(limit Limit, now time.Time) Result {
+	refillTime := time.Duration(float64(limit.Burst) * float64(limit.Window) / float64(limit.Requests))
+
+	entry := s.get(limit.Key+":bucket", now, now.Add(refillTime))
+	if entry.counted.IsZero() {
+		entry.tokens = float64(limit.Burst)
+		entry.counted = now
+	}
+
+	if now.After(entry.counted) {
+		refill := float64(now.Sub(entry.counted)) * float64(limit.Requests) / float64(limit.Window)
+		entry.tokens = math.Min(float64(limit.Burst), entry.tokens+refill)
+		entry.counted = now
+	}
+
+	entry.expires = now.Add(refillTime)
+
+	perToken := float64(limit.Window) / float64(limit.Requests)
+	result := Result{Limit: limit.Burst}
+
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - entry.tokens) * perToken))
+	}
+
+	result.Remaining = int(math.Floor(entry.tokens))
+	result.Reset = time.Duration(math.Ceil((float64(limit.Burst) - entry.tokens) * perToken))
+
+	return result
+}
@@ -0,0 +1,124 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+)
+
+func TestMemoryPoolIncr(t *testing.T) {
+	conn := ratelimit.NewMemoryPool(100).Connect()
+	defer conn.Close()
+
+	for i := 1; i <= 3; i++ {
+		count, ttl, err := conn.Incr("key", 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, i, count)
+		assert.Positive(t, ttl)
+		assert.LessOrEqual(t, ttl, 50*time.Millisecond)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	count, _, err := conn.Incr("key", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryPoolEvictsLeastRecentlyUsed(t *testing.T) {
+	// The keys are spread over 16 shards of one key each.
+	conn := ratelimit.NewMemoryPool(16).Connect()
+
+	for i := range 1000 {
+		_, _, err := conn.Incr(fmt.Sprint(i), time.Minute)
+		require.NoError(t, err)
+	}
+
+	count, _, err := conn.Incr("999", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, _, err = conn.Incr("0", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func take(t *testing.T, conn ratelimit.Connection, limit ratelimit.Limit, now time.Time) ratelimit.Result {
+	t.Helper()
+
+	result, err := conn.Take(limit, now)
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestMemoryPoolSlidingWindowLog(t *testing.T) {
+	conn := ratelimit.NewMemoryPool(100).Connect()
+	limit := ratelimit.Limit{Key: "log", Requests: 2, Window: time.Minute, Algorithm: ratelimit.SlidingWindowLog, Burst: 2}
+	start := time.Unix(1000*60, 0)
+
+	assert.True(t, take(t, conn, limit, start).Allowed)
+
+	result := take(t, conn, limit, start.Add(30*time.Second))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, time.Minute, result.Reset)
+
+	result = take(t, conn, limit, start.Add(45*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 15*time.Second, result.RetryAfter)
+
+	// Unlike a fixed window, the limit holds across the minute boundary.
+	assert.False(t, take(t, conn, limit, start.Add(59*time.Second)).Allowed)
+	assert.True(t, take(t, conn, limit, start.Add(61*time.Second)).Allowed)
+}
+
+func TestMemoryPoolSlidingWindowCounter(t *testing.T) {
+	conn := ratelimit.NewMemoryPool(100).Connect()
+	limit := ratelimit.Limit{Key: "counter", Requests: 4, Window: time.Minute, Algorithm: ratelimit.SlidingWindowCounter, Burst: 4}
+	start := time.Unix(1000*60, 0)
+
+	for range 4 {
+		assert.True(t, take(t, conn, limit, start.Add(50*time.Second)).Allowed)
+	}
+
+	result := take(t, conn, limit, start.Add(50*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+	// A quarter into the next window three quarters of the previous
+	// window's requests still count.
+	assert.True(t, take(t, conn, limit, start.Add(75*time.Second)).Allowed)
+	assert.False(t, take(t, conn, limit, start.Add(75*time.Second)).Allowed)
+
+	// Halfway into the next window half of them count.
+	result = take(t, conn, limit, start.Add(90*time.Second))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryPoolTokenBucket(t *testing.T) {
+	conn := ratelimit.NewMemoryPool(100).Connect()
+	limit := ratelimit.Limit{Key: "bucket", Requests: 1, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 3}
+	start := time.Unix(1000, 0)
+
+	for i := range 3 {
+		result := take(t, conn, limit, start)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+	}
+
+	result := take(t, conn, limit, start.Add(500*time.Millisecond))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 2500*time.Millisecond, result.Reset)
+
+	assert.True(t, take(t, conn, limit, start.Add(time.Second)).Allowed)
+	assert.False(t, take(t, conn, limit, start.Add(time.Second)).Allowed)
+}
@@ -0,0 +1,315 @@
+package ratelimit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a declarative configuration of rate limits, typically loaded
+// from a YAML or JSON file, so limits can be changed without code changes.
+//
+//	trustedProxies: [10.0.0.0/8]
+//	maxBodySize: 65536
+//	limits:
+//	  - method: GET
+//	    path: /reports/{id}
+//	    requests: 10
+//	    window: 1m
+//	    algorithm: token-bucket
+//	    burst: 20
+//	    key: [user-id, path:id]
+//	    hash: true
+//	    onFailure: closed
+//	    onMissingKey: count
+type Policy struct {
+	// TrustedProxies are the addresses or prefixes of the proxies allowed
+	// to set X-Forwarded-For, used by the client-ip key.
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies"`
+	// MaxBodySize is the size in bytes of the largest body read by the
+	// body keys, it defaults to DefaultMaxBodySize.
+	MaxBodySize int64         `json:"maxBodySize" yaml:"maxBodySize"`
+	Limits      []PolicyLimit `json:"limits" yaml:"limits"`
+}
+
+// PolicyLimit is a limit for a route in a Policy.
+type PolicyLimit struct {
+	Method string `json:"method" yaml:"method"`
+	// Path is the mux path template of the route.
+	Path     string `json:"path" yaml:"path"`
+	Requests int    `json:"requests" yaml:"requests"`
+	// Window is parsed with time.ParseDuration and defaults to a minute.
+	Window    string    `json:"window" yaml:"window"`
+	Algorithm Algorithm `json:"algorithm" yaml:"algorithm"`
+	Burst     int       `json:"burst" yaml:"burst"`
+	// Key lists the parts of the key, joined by ":". The parts are
+	// client-ip, user-id, client-id, header:<name>, path:<variable> and
+	// body:<field>. Without any parts all requests to the route share the
+	// limit.
+	Key []string `json:"key" yaml:"key"`
+	// Hash hashes the key, to avoid storing personal data in clear text.
+	Hash bool `json:"hash" yaml:"hash"`
+	// OnFailure is the FailurePolicy, "open" or "closed". A route fails
+	// closed if any of its limits does.
+	OnFailure FailurePolicy `json:"onFailure" yaml:"onFailure"`
+	// OnMissingKey is the MissingKeyPolicy, "count" or "skip".
+	OnMissingKey MissingKeyPolicy `json:"onMissingKey" yaml:"onMissingKey"`
+}
+
+// MissingKeyPolicy decides how a limit of a Policy handles requests missing a
+// part of its key, e.g. the user-id of an unauthenticated request.
+type MissingKeyPolicy int
+
+const (
+	// CountMissing counts the requests missing a part of the key against a
+	// key shared by all of them, so clients can't avoid the limit by
+	// leaving out a header or field. It's the default.
+	CountMissing MissingKeyPolicy = iota
+	// SkipMissing doesn't apply the limit to requests missing a part of the
+	// key.
+	SkipMissing
+)
+
+// missingKey is the key of the requests missing a part of the key of a limit
+// counted by CountMissing.
+const missingKey = "<missing>"
+
+// MarshalText implements encoding.TextMarshaler.
+func (p MissingKeyPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case CountMissing:
+		return []byte("count"), nil
+	case SkipMissing:
+		return []byte("skip"), nil
+	default:
+		return nil, fmt.Errorf("unknown missing key policy %d", int(p))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *MissingKeyPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "count":
+		*p = CountMissing
+	case "skip":
+		*p = SkipMissing
+	default:
+		return fmt.Errorf("unknown missing key policy %q", text)
+	}
+
+	return nil
+}
+
+// ParsePolicy parses a Policy in YAML or JSON. Unknown fields are rejected
+// to catch typos.
+func ParsePolicy(data []byte) (Policy, error) {
+	var policy Policy
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&policy); err != nil {
+		return Policy{}, fmt.Errorf("failed to parse rate limit policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// LoadPolicy reads and parses the Policy in the file at path.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to read rate limit policy: %w", err)
+	}
+
+	return ParsePolicy(data)
+}
+
+type policyLimit struct {
+	limit        Limit
+	keyFunc      KeyFunc
+	onMissingKey MissingKeyPolicy
+}
+
+// ApplyPolicy configures the limits of policy. The limits of a route replace
+// any previous configuration of the route, other routes are left unchanged.
+//
+// The requests missing a part of the key of a limit, e.g. the user-id of an
+// unauthenticated request, share a single key unless the limit has the
+// SkipMissing policy.
+//
+// The counters of a limit are identified by its method, path, algorithm,
+// window and key parts, so applying a policy with the limits reordered keeps
+// them. Limits with the same of all of these are rejected.
+func (l *Limiter) ApplyPolicy(policy Policy) error {
+	trustedProxies := make([]netip.Prefix, 0, len(policy.TrustedProxies))
+
+	for _, proxy := range policy.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+
+		trustedProxies = append(trustedProxies, prefix)
+	}
+
+	maxBodySize := policy.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	routes := map[Request][]policyLimit{}
+	failurePolicies := map[Request]FailurePolicy{}
+	// indexes are the indexes of the limits by key prefix.
+	indexes := map[string]int{}
+
+	for i, config := range policy.Limits {
+		compiled, err := compilePolicyLimit(config, trustedProxies, maxBodySize)
+		if err != nil {
+			return fmt.Errorf("invalid limit %d for %s %s: %w", i, config.Method, config.Path, err)
+		}
+
+		if previous, found := indexes[compiled.limit.Key]; found {
+			return fmt.Errorf("invalid limit %d for %s %s: same key, window and algorithm as limit %d", i, config.Method, config.Path, previous)
+		}
+
+		indexes[compiled.limit.Key] = i
+
+		route := Request{Method: strings.ToUpper(config.Method), PathTemplate: config.Path}
+		routes[route] = append(routes[route], compiled)
+
+		if config.OnFailure == FailClosed {
+			failurePolicies[route] = FailClosed
+		}
+	}
+
+	for route, limits := range routes {
+		l.Configure(route, policyGenerator(limits), WithFailurePolicy(failurePolicies[route]))
+	}
+
+	return nil
+}
+
+func policyGenerator(limits []policyLimit) limitGenerator {
+	return func(req *http.Request) ([]Limit, error) {
+		result := make([]Limit, 0, len(limits))
+
+		for _, config := range limits {
+			key, err := config.keyFunc(req)
+
+			switch {
+			case errors.Is(err, ErrKeyNotFound) && config.onMissingKey == SkipMissing:
+				continue
+			case errors.Is(err, ErrKeyNotFound):
+				key = missingKey
+			case err != nil:
+				return nil, err
+			}
+
+			limit := config.limit
+			limit.Key += key
+			result = append(result, limit)
+		}
+
+		return result, nil
+	}
+}
+
+func compilePolicyLimit(config PolicyLimit, trustedProxies []netip.Prefix, maxBodySize int64) (policyLimit, error) {
+	if config.Method == "" || config.Path == "" {
+		return policyLimit{}, errors.New("method and path are required")
+	}
+
+	if config.Requests <= 0 {
+		return policyLimit{}, errors.New("requests must be positive")
+	}
+
+	window := time.Minute
+
+	if config.Window != "" {
+		var err error
+		if window, err = time.ParseDuration(config.Window); err != nil {
+			return policyLimit{}, err
+		}
+	}
+
+	keyFuncs := make([]KeyFunc, 0, len(config.Key))
+
+	for _, part := range config.Key {
+		keyFunc, err := parseKeyPart(part, trustedProxies, maxBodySize)
+		if err != nil {
+			return policyLimit{}, err
+		}
+
+		keyFuncs = append(keyFuncs, keyFunc)
+	}
+
+	keyFunc := Compose(keyFuncs...)
+	if config.Hash {
+		keyFunc = Hashed(keyFunc)
+	}
+
+	return policyLimit{
+		limit: Limit{
+			// The prefix keeps the keys of different limits apart. It's
+			// derived from what the counters count, so they are kept when
+			// the limits are reordered or the number of requests changes.
+			Key: fmt.Sprintf("%s %s %s/%s[%s]:",
+				strings.ToUpper(config.Method), config.Path, config.Algorithm, window, strings.Join(config.Key, ",")),
+			Requests:  config.Requests,
+			Window:    window,
+			Algorithm: config.Algorithm,
+			Burst:     config.Burst,
+		},
+		keyFunc:      keyFunc,
+		onMissingKey: config.OnMissingKey,
+	}, nil
+}
+
+func parseKeyPart(part string, trustedProxies []netip.Prefix, maxBodySize int64) (KeyFunc, error) {
+	kind, argument, _ := strings.Cut(part, ":")
+
+	switch kind {
+	case "client-ip":
+		return ClientIP(trustedProxies...), nil
+	case "user-id":
+		return UserID(), nil
+	case "client-id":
+		return ClientID(), nil
+	}
+
+	if argument == "" {
+		return nil, fmt.Errorf("unknown key %q", part)
+	}
+
+	switch kind {
+	case "header":
+		return Header(argument), nil
+	case "path":
+		return PathVariable(argument), nil
+	case "body":
+		return BodyFieldWithMaxSize(argument, maxBodySize), nil
+	default:
+		return nil, fmt.Errorf("unknown key %q", part)
+	}
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,235 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+	"github.com/SKF/go-utility/v2/useridcontext"
+)
+
+const policyYAML = `
+trustedProxies: [10.0.0.1]
+limits:
+  - method: get
+    path: /reports/{id}
+    requests: 2
+    window: 1h
+    algorithm: sliding-window-log
+    key: [user-id, path:id]
+    hash: true
+  - method: GET
+    path: /reports/{id}
+    requests: 3
+    key: [client-ip]
+    onFailure: closed
+`
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy([]byte(policyYAML))
+	require.NoError(t, err)
+
+	require.Len(t, policy.Limits, 2)
+	assert.Equal(t, []string{"10.0.0.1"}, policy.TrustedProxies)
+	assert.Equal(t, ratelimit.SlidingWindowLog, policy.Limits[0].Algorithm)
+	assert.Equal(t, "1h", policy.Limits[0].Window)
+	assert.Equal(t, ratelimit.FailOpen, policy.Limits[0].OnFailure)
+	assert.Equal(t, ratelimit.FailClosed, policy.Limits[1].OnFailure)
+
+	policy, err = ratelimit.ParsePolicy([]byte(`{"limits": [{"method": "GET", "path": "/", "requests": 1, "algorithm": "token-bucket"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.TokenBucket, policy.Limits[0].Algorithm)
+
+	_, err = ratelimit.ParsePolicy([]byte(`{"limits": [{"method": "GET", "path": "/", "request": 1}]}`))
+	require.Error(t, err)
+
+	_, err = ratelimit.ParsePolicy([]byte(`{"limits": [{"algorithm": "leaky-bucket"}]}`))
+	require.Error(t, err)
+}
+
+func TestApplyPolicyErrors(t *testing.T) {
+	for _, policy := range []ratelimit.Policy{
+		{TrustedProxies: []string{"proxy"}},
+		{Limits: []ratelimit.PolicyLimit{{Path: "/", Requests: 1}}},
+		{Limits: []ratelimit.PolicyLimit{{Method: "GET", Path: "/"}}},
+		{Limits: []ratelimit.PolicyLimit{{Method: "GET", Path: "/", Requests: 1, Window: "hour"}}},
+		{Limits: []ratelimit.PolicyLimit{{Method: "GET", Path: "/", Requests: 1, Key: []string{"cookie"}}}},
+		{Limits: []ratelimit.PolicyLimit{{Method: "GET", Path: "/", Requests: 1, Key: []string{"path:"}}}},
+		{Limits: []ratelimit.PolicyLimit{
+			{Method: "GET", Path: "/", Requests: 1, Key: []string{"user-id"}},
+			{Method: "get", Path: "/", Requests: 2, Window: "1m", Key: []string{"user-id"}},
+		}},
+	} {
+		limiter := &ratelimit.Limiter{}
+		require.Error(t, limiter.ApplyPolicy(policy))
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy([]byte(policyYAML))
+	require.NoError(t, err)
+
+	limiter := &ratelimit.Limiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	require.NoError(t, limiter.ApplyPolicy(policy))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/reports/{id}", handler)
+	r.Use(limiter.Middleware())
+
+	serve := func(userID, id, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/reports/"+id, nil)
+		req.RemoteAddr = remoteAddr
+
+		if userID != "" {
+			req = req.WithContext(useridcontext.NewContext(req.Context(), userID))
+		}
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	// The user limit applies per user and report.
+	assert.Equal(t, http.StatusOK, serve("user", "1", "192.0.2.1:1").Code)
+	assert.Equal(t, http.StatusOK, serve("user", "1", "192.0.2.2:1").Code)
+
+	resp := serve("user", "1", "192.0.2.3:1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "3600", resp.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve("user", "2", "192.0.2.1:1").Code)
+
+	// The requests without a user share a key of the user limit.
+	assert.Equal(t, http.StatusOK, serve("", "1", "192.0.2.4:1").Code)
+	assert.Equal(t, http.StatusOK, serve("", "2", "192.0.2.5:1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("", "3", "192.0.2.6:1").Code)
+}
+
+func TestApplyPolicyMissingKey(t *testing.T) {
+	for _, test := range []struct {
+		policy   ratelimit.MissingKeyPolicy
+		expected int
+	}{
+		{ratelimit.CountMissing, http.StatusTooManyRequests},
+		{ratelimit.SkipMissing, http.StatusOK},
+	} {
+		limiter := &ratelimit.Limiter{}
+		limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+		require.NoError(t, limiter.ApplyPolicy(ratelimit.Policy{
+			Limits: []ratelimit.PolicyLimit{
+				{Method: http.MethodGet, Path: "/reports/{id}", Requests: 1, Key: []string{"header:X-Api-Key"}, OnMissingKey: test.policy},
+			},
+		}))
+
+		r := mux.NewRouter()
+		r.HandleFunc("/reports/{id}", handler)
+		r.Use(limiter.Middleware())
+
+		serve := func(apiKey string) int {
+			req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+			if apiKey != "" {
+				req.Header.Set("X-Api-Key", apiKey)
+			}
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			return resp.Code
+		}
+
+		assert.Equal(t, http.StatusOK, serve(""))
+		assert.Equal(t, test.expected, serve(""), test.policy)
+
+		// The requests with the header are counted by its value.
+		assert.Equal(t, http.StatusOK, serve("key"))
+		assert.Equal(t, http.StatusTooManyRequests, serve("key"))
+	}
+
+	var policy ratelimit.MissingKeyPolicy
+	require.NoError(t, policy.UnmarshalText([]byte("skip")))
+	assert.Equal(t, ratelimit.SkipMissing, policy)
+	require.Error(t, policy.UnmarshalText([]byte("ignore")))
+}
+
+func TestAlgorithmText(t *testing.T) {
+	text, err := ratelimit.TokenBucket.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "token-bucket", string(text))
+	assert.Equal(t, "Algorithm(10)", ratelimit.Algorithm(10).String())
+
+	var algorithm ratelimit.Algorithm
+	require.NoError(t, algorithm.UnmarshalText([]byte("sliding-window-counter")))
+	assert.Equal(t, ratelimit.SlidingWindowCounter, algorithm)
+}
+
+func TestApplyPolicyReordered(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy([]byte(policyYAML))
+	require.NoError(t, err)
+
+	// The user limit doesn't apply to the requests without a user.
+	policy.Limits[0].OnMissingKey = ratelimit.SkipMissing
+
+	limiter := &ratelimit.Limiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	require.NoError(t, limiter.ApplyPolicy(policy))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/reports/{id}", handler)
+	r.Use(limiter.Middleware())
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+		req.RemoteAddr = "192.0.2.1:1"
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		return resp.Code
+	}
+
+	for range 3 {
+		assert.Equal(t, http.StatusOK, serve())
+	}
+
+	// The client IP limit keeps its counter when it's moved.
+	policy.Limits[0], policy.Limits[1] = policy.Limits[1], policy.Limits[0]
+	require.NoError(t, limiter.ApplyPolicy(policy))
+
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+}
+
+func TestApplyPolicyMaxBodySize(t *testing.T) {
+	limiter := &ratelimit.Limiter{}
+	limiter.SetConnectionPool(ratelimit.NewMemoryPool(100))
+	require.NoError(t, limiter.ApplyPolicy(ratelimit.Policy{
+		MaxBodySize: 32,
+		Limits: []ratelimit.PolicyLimit{
+			{Method: http.MethodPost, Path: "/reports", Requests: 1, Key: []string{"body:email"}},
+		},
+	}))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/reports", handler)
+	r.Use(limiter.Middleware())
+
+	serve := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(body))
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(`{"email": "a.b@example.com"}`))
+	assert.Equal(t, http.StatusTooManyRequests, serve(`{"email": "a.b@example.com"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(`{"email": "a.b@example.com", "padding": "..."}`))
+}
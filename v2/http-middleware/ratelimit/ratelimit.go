@@ -2,9 +2,12 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	http_model "github.com/SKF/go-utility/v2/http-model"
@@ -23,12 +26,151 @@ type ConnectionPool interface {
 type Connection interface {
 	io.Closer
 
-	Incr(key string) (int, error)
+	// Incr increments the counter stored at key and returns the new count
+	// and the remaining time to live of the counter. The counter is set to
+	// expire after expiry when it's created.
+	Incr(key string, expiry time.Duration) (count int, ttl time.Duration, err error)
+
+	// Take records a request for limit at now, using the sliding window or
+	// token bucket algorithm of limit, and returns the result. Requests,
+	// Window and Burst of limit are always set. The check and the update must
+	// be atomic, since several instances may share the same keys.
+	Take(limit Limit, now time.Time) (Result, error)
+}
+
+// BatchConnection is a Connection able to check several limits in a single
+// call. Every limit is counted, even when another limit denies the request.
+type BatchConnection interface {
+	Connection
+
+	// TakeAll checks limits at now and returns a Result for each limit.
+	// The algorithm of each limit is used, including FixedWindow. Requests,
+	// Window and Burst of the limits are always set.
+	TakeAll(limits []Limit, now time.Time) ([]Result, error)
+}
+
+// Result is the outcome of checking a request against a Limit.
+type Result struct {
+	Allowed bool
+	// Limit is the quota of the Limit, Requests or Burst when using
+	// TokenBucket.
+	Limit int
+	// Remaining is the number of requests left of the quota.
+	Remaining int
+	// Reset is the time until the quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until a request may be allowed again, only set
+	// when the request isn't allowed.
+	RetryAfter time.Duration
+}
+
+// moreRestrictiveThan returns whether r is more restrictive than other. A
+// denial is more restrictive than an allowed request, and the denial with
+// the longest RetryAfter is the most restrictive.
+func (r Result) moreRestrictiveThan(other Result) bool {
+	if r.Allowed != other.Allowed {
+		return !r.Allowed
+	}
+
+	if !r.Allowed {
+		return r.RetryAfter > other.RetryAfter
+	}
+
+	if r.Remaining != other.Remaining {
+		return r.Remaining < other.Remaining
+	}
+
+	return r.Reset > other.Reset
+}
+
+// Algorithm is the algorithm used to enforce a Limit.
+type Algorithm int
+
+const (
+	// FixedWindow counts the requests in consecutive windows. A client can
+	// send up to twice the limit across a window boundary.
+	FixedWindow Algorithm = iota
+	// SlidingWindowLog stores the time of each request and counts the
+	// requests within the last window. It's exact, but stores one entry per
+	// request.
+	SlidingWindowLog
+	// SlidingWindowCounter approximates the number of requests within the
+	// last window by weighting the count of the previous fixed window.
+	SlidingWindowCounter
+	// TokenBucket refills Requests tokens per Window up to Burst tokens, and
+	// each request takes a token.
+	TokenBucket
+)
+
+var algorithmNames = map[Algorithm]string{
+	FixedWindow:          "fixed-window",
+	SlidingWindowLog:     "sliding-window-log",
+	SlidingWindowCounter: "sliding-window-counter",
+	TokenBucket:          "token-bucket",
+}
+
+func (a Algorithm) String() string {
+	if name, found := algorithmNames[a]; found {
+		return name
+	}
+
+	return fmt.Sprintf("Algorithm(%d)", int(a))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a Algorithm) MarshalText() ([]byte, error) {
+	if name, found := algorithmNames[a]; found {
+		return []byte(name), nil
+	}
+
+	return nil, fmt.Errorf("unknown algorithm %d", int(a))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Algorithm) UnmarshalText(text []byte) error {
+	for algorithm, name := range algorithmNames {
+		if name == string(text) {
+			*a = algorithm
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown algorithm %q", text)
 }
 
 type Limit struct {
+	// Deprecated: Use Requests and Window instead. Used when Requests
+	// isn't set.
 	RequestPerMinute int
 	Key              string
+
+	// Requests is the number of requests allowed per Window.
+	Requests int
+	// Window defaults to a minute.
+	Window time.Duration
+	// Algorithm defaults to FixedWindow.
+	Algorithm Algorithm
+	// Burst is the capacity of the bucket when using TokenBucket, it
+	// defaults to Requests.
+	Burst int
+}
+
+// normalize returns the limit with the defaults of Requests, Window and
+// Burst applied.
+func (l Limit) normalize() Limit {
+	if l.Requests == 0 {
+		l.Requests = l.RequestPerMinute
+	}
+
+	if l.Window <= 0 {
+		l.Window = time.Minute
+	}
+
+	if l.Burst <= 0 {
+		l.Burst = l.Requests
+	}
+
+	return l
 }
 
 type Request struct {
@@ -36,13 +178,73 @@ type Request struct {
 	PathTemplate string
 }
 
+// FailurePolicy decides what happens to a request when the limits can't be
+// checked, e.g. because Redis is unreachable.
+type FailurePolicy int
+
+const (
+	// FailOpen lets the request through. It's the default.
+	FailOpen FailurePolicy = iota
+	// FailClosed rejects the request with 503 Service Unavailable.
+	FailClosed
+)
+
+// MarshalText implements encoding.TextMarshaler, using "open" and "closed".
+func (p FailurePolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case FailOpen:
+		return []byte("open"), nil
+	case FailClosed:
+		return []byte("closed"), nil
+	default:
+		return nil, fmt.Errorf("unknown failure policy %d", int(p))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *FailurePolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "open":
+		*p = FailOpen
+	case "closed":
+		*p = FailClosed
+	default:
+		return fmt.Errorf("unknown failure policy %q", text)
+	}
+
+	return nil
+}
+
 type Limiter struct {
 	connectionPool ConnectionPool
-	configs        map[Request]limitGenerator
+
+	lock    sync.RWMutex
+	configs map[Request]routeConfig
 }
 
 type limitGenerator func(*http.Request) ([]Limit, error)
 
+type routeConfig struct {
+	generator     limitGenerator
+	failurePolicy FailurePolicy
+
+	// maxInFlight, queueTimeout and slotExpiry are used by the
+	// ConcurrencyLimiter.
+	maxInFlight  int
+	queueTimeout time.Duration
+	slotExpiry   time.Duration
+}
+
+// RouteOption configures a route of the Limiter.
+type RouteOption func(*routeConfig)
+
+// WithFailurePolicy sets the FailurePolicy of the route.
+func WithFailurePolicy(policy FailurePolicy) RouteOption {
+	return func(c *routeConfig) {
+		c.failurePolicy = policy
+	}
+}
+
 func (l *Limiter) SetConnectionPool(p ConnectionPool) *Limiter {
 	l.connectionPool = p
 	return l
@@ -53,16 +255,27 @@ func (l *Limiter) SetConnectionPool(p ConnectionPool) *Limiter {
 // path.pathTemplate should be the pathtemplate used to route the request
 //
 // If you give multiple configs for 1 endpoint. The most restrictive one will apply
-func (l *Limiter) Configure(path Request, gen limitGenerator) {
+func (l *Limiter) Configure(path Request, gen limitGenerator, opts ...RouteOption) {
+	config := routeConfig{generator: gen}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
 	if l.configs == nil {
-		l.configs = map[Request]limitGenerator{}
+		l.configs = map[Request]routeConfig{}
 	}
 
-	l.configs[path] = gen
+	l.configs[path] = config
 }
 
 // Rate limiting middleware, you can configure 1 or many limits for each path template using a limitGenerator
-// The algorithm is inspired from: https://redislabs.com/redis-best-practices/basic-rate-limiting/
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set from the most restrictive limit,
+// and Retry-After is set when the request is rejected.
+// The FixedWindow algorithm is inspired from: https://redislabs.com/redis-best-practices/basic-rate-limiting/
+// and is used unless another Algorithm is set on the Limit.
 //
 // The key will be stored in clear text in the cache. If the key contains personal data please consider hashing the key
 func (l *Limiter) Middleware() mux.MiddlewareFunc {
@@ -84,10 +297,18 @@ func (l *Limiter) Middleware() mux.MiddlewareFunc {
 				return
 			}
 
-			configGenerator, ok := l.configs[Request{Method: req.Method, PathTemplate: pathTemplate}]
+			l.lock.RLock()
+			config, ok := l.configs[Request{Method: req.Method, PathTemplate: pathTemplate}]
+			l.lock.RUnlock()
+
 			if ok {
-				cfgs, err := configGenerator(req)
-				if err != nil {
+				cfgs, err := config.generator(req)
+				if errors.Is(err, ErrBodyTooLarge) {
+					span.End()
+					http_server.WriteJSONResponse(ctx, w, req, http.StatusRequestEntityTooLarge, http_model.ErrResponseRequestEntityTooLarge)
+
+					return
+				} else if err != nil {
 					log.WithTracing(ctx).WithError(err).Error("Failed to generate limits")
 					span.End()
 					next.ServeHTTP(w, req)
@@ -95,16 +316,26 @@ func (l *Limiter) Middleware() mux.MiddlewareFunc {
 					return
 				}
 
-				tooManyRequest, err := l.checkAccessCounts(ctx, cfgs, now)
+				result, err := l.checkAccessCounts(ctx, cfgs, now)
 				if err != nil {
 					log.WithTracing(ctx).WithError(err).Errorf("failed to check limit")
 					span.End()
+
+					if config.failurePolicy == FailClosed {
+						http_server.WriteJSONResponse(ctx, w, req, http.StatusServiceUnavailable, http_model.ErrResponseServiceUnavailable)
+						return
+					}
+
 					next.ServeHTTP(w, req)
 
 					return
 				}
 
-				if tooManyRequest {
+				if len(cfgs) > 0 {
+					writeHeaders(w, result)
+				}
+
+				if !result.Allowed {
 					http_server.WriteJSONResponse(ctx, w, req, http.StatusTooManyRequests, http_model.ErrResponseTooManyRequests)
 					span.End()
 
@@ -118,25 +349,125 @@ func (l *Limiter) Middleware() mux.MiddlewareFunc {
 	}
 }
 
-func (l *Limiter) checkAccessCounts(ctx context.Context, cfgs []Limit, now time.Time) (tooManyRequests bool, err error) {
+// checkAccessCounts checks every limit and returns the result of the most
+// restrictive one.
+func (l *Limiter) checkAccessCounts(ctx context.Context, cfgs []Limit, now time.Time) (Result, error) {
 	_, span := trace.StartSpan(ctx, "RateLimitMiddleware/checkAccessCounts")
 	defer span.End()
 
 	db := l.connectionPool.Connect()
 	defer db.Close()
 
-	for _, config := range cfgs {
-		key := fmt.Sprintf("%s:%d", config.Key, now.Minute())
+	limits := make([]Limit, len(cfgs))
+	for i, config := range cfgs {
+		limits[i] = config.normalize()
+	}
 
-		resp, err := db.Incr(key)
+	results, err := takeAll(db, limits, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	mostRestrictive := Result{Allowed: true}
+
+	for i, result := range results {
+		if i == 0 || result.moreRestrictiveThan(mostRestrictive) {
+			mostRestrictive = result
+		}
+	}
+
+	return mostRestrictive, nil
+}
+
+// takeAll checks the limits in a single call if db is a BatchConnection.
+// Otherwise the limits are checked one by one, and the remaining limits are
+// not checked once a limit denies the request.
+func takeAll(db Connection, limits []Limit, now time.Time) ([]Result, error) {
+	if batch, ok := db.(BatchConnection); ok && len(limits) > 1 {
+		results, err := batch.TakeAll(limits, now)
 		if err != nil {
-			return false, fmt.Errorf("incr failed: %w", err)
+			return nil, fmt.Errorf("take all failed: %w", err)
 		}
 
-		if resp > config.RequestPerMinute {
-			return true, nil
+		return results, nil
+	}
+
+	results := make([]Result, 0, len(limits))
+
+	for _, limit := range limits {
+		result, err := take(db, limit, now)
+		if err != nil {
+			return nil, err
 		}
+
+		results = append(results, result)
+
+		if !result.Allowed {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+func take(db Connection, limit Limit, now time.Time) (Result, error) {
+	if limit.Algorithm == FixedWindow {
+		key, expiry := fixedWindowKey(limit, now)
+
+		count, ttl, err := db.Incr(key, expiry)
+		if err != nil {
+			return Result{}, fmt.Errorf("incr failed: %w", err)
+		}
+
+		return fixedWindowResult(limit, count, ttl), nil
+	}
+
+	result, err := db.Take(limit, now)
+	if err != nil {
+		return Result{}, fmt.Errorf("take failed: %w", err)
+	}
+
+	return result, nil
+}
+
+// fixedWindowKey returns the key of the counter of the window of now, and
+// the time until the window ends.
+func fixedWindowKey(limit Limit, now time.Time) (string, time.Duration) {
+	window := now.UnixNano() / int64(limit.Window)
+	windowEnd := time.Unix(0, (window+1)*int64(limit.Window))
+
+	return fmt.Sprintf("%s:%d", hashTag(limit.Key), window), windowEnd.Sub(now)
+}
+
+func fixedWindowResult(limit Limit, count int, ttl time.Duration) Result {
+	result := Result{
+		Allowed:   count <= limit.Requests,
+		Limit:     limit.Requests,
+		Remaining: max(0, limit.Requests-count),
+		Reset:     ttl,
+	}
+
+	if !result.Allowed {
+		result.RetryAfter = ttl
+	}
+
+	return result
+}
+
+func writeHeaders(w http.ResponseWriter, result Result) {
+	w.Header().Set(http_model.HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+	w.Header().Set(http_model.HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+	w.Header().Set(http_model.HeaderRateLimitReset, strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		w.Header().Set(http_model.HeaderRetryAfter, strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
 	}
 
-	return false, nil
+	return int((d + time.Second - 1) / time.Second)
 }
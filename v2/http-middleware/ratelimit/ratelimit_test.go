@@ -1,11 +1,13 @@
 package ratelimit_test
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/mock"
@@ -24,7 +26,7 @@ func TestRateLimitOk(t *testing.T) {
 	connMock := &ConnectionMock{}
 	poolMock.On("Connect").Return(connMock).Once()
 
-	connMock.On("Incr", mock.Anything).Return(0, nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(0, time.Minute, nil)
 	connMock.On("Close").Return(nil).Once()
 
 	// ACT
@@ -59,7 +61,7 @@ func TestRateLimitTooMany(t *testing.T) {
 	connMock := &ConnectionMock{}
 	poolMock.On("Connect").Return(connMock).Once()
 
-	connMock.On("Incr", mock.Anything).Return(10, nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(10, time.Minute, nil)
 	connMock.On("Close").Return(nil).Once()
 
 	// ACT
@@ -95,7 +97,7 @@ func TestUseCorrectLimit(t *testing.T) {
 	connMock := &ConnectionMock{}
 	poolMock.On("Connect").Return(connMock).Once()
 
-	connMock.On("Incr", mock.Anything).Return(10, nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(10, time.Minute, nil)
 	connMock.On("Close").Return(nil).Once()
 
 	// ACT
@@ -178,7 +180,7 @@ func TestReadBodyInMiddleware(t *testing.T) {
 	connMock := &ConnectionMock{}
 	poolMock.On("Connect").Return(connMock).Once()
 
-	connMock.On("Incr", mock.Anything).Return(10, nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(10, time.Minute, nil)
 	connMock.On("Close").Return(nil).Once()
 
 	// ACT
@@ -239,7 +241,7 @@ func TestUseDynamicRoute(t *testing.T) {
 	connMock := &ConnectionMock{}
 	poolMock.On("Connect").Return(connMock)
 
-	connMock.On("Incr", mock.Anything).Return(10, nil)
+	connMock.On("Incr", mock.Anything, mock.Anything).Return(10, time.Minute, nil)
 	connMock.On("Close").Return(nil)
 
 	// ACT
@@ -279,6 +281,212 @@ func TestUseDynamicRoute(t *testing.T) {
 	require.Equal(t, http.StatusOK, resp3.Code)
 }
 
+func TestLimitWindows(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		limit  ratelimit.Limit
+		expiry time.Duration
+	}{
+		{name: "per minute", limit: ratelimit.Limit{RequestPerMinute: 5}, expiry: time.Minute},
+		{name: "per second", limit: ratelimit.Limit{Requests: 5, Window: time.Second}, expiry: time.Second},
+		{name: "per hour", limit: ratelimit.Limit{Requests: 5, Window: time.Hour}, expiry: time.Hour},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, r := getRouterAndRequest(t)
+
+			poolMock := &ConnectionPoolMock{}
+			connMock := &ConnectionMock{}
+			poolMock.On("Connect").Return(connMock).Once()
+
+			connMock.On("Incr", mock.MatchedBy(func(key string) bool {
+				return strings.HasPrefix(key, "{"+ratelimit.HashKey("/apa")+"}:")
+			}), mock.MatchedBy(func(expiry time.Duration) bool {
+				return expiry > 0 && expiry <= test.expiry
+			})).Return(6, test.expiry, nil).Once()
+			connMock.On("Close").Return(nil).Once()
+
+			limiter := &ratelimit.Limiter{}
+			limiter.SetConnectionPool(poolMock)
+			limiter.Configure(
+				ratelimit.Request{Method: http.MethodGet, PathTemplate: "/apa"},
+				func(req *http.Request) ([]ratelimit.Limit, error) {
+					limit := test.limit
+					limit.Key = req.URL.Path
+
+					return []ratelimit.Limit{limit}, nil
+				},
+			)
+			r.Use(limiter.Middleware())
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			poolMock.AssertExpectations(t)
+			connMock.AssertExpectations(t)
+
+			require.Equal(t, http.StatusTooManyRequests, resp.Code)
+		})
+	}
+}
+
+func TestLimitAlgorithms(t *testing.T) {
+	for _, algorithm := range []ratelimit.Algorithm{
+		ratelimit.SlidingWindowLog,
+		ratelimit.SlidingWindowCounter,
+		ratelimit.TokenBucket,
+	} {
+		for _, allowed := range []bool{true, false} {
+			req, r := getRouterAndRequest(t)
+
+			poolMock := &ConnectionPoolMock{}
+			connMock := &ConnectionMock{}
+			poolMock.On("Connect").Return(connMock).Once()
+
+			// The defaults are applied before the limit is passed on.
+			expectedLimit := ratelimit.Limit{
+				Key:       "/apa",
+				Requests:  5,
+				Window:    time.Second,
+				Algorithm: algorithm,
+				Burst:     5,
+			}
+			connMock.On("Take", expectedLimit, mock.Anything).Return(ratelimit.Result{Allowed: allowed}, nil).Once()
+			connMock.On("Close").Return(nil).Once()
+
+			limiter := &ratelimit.Limiter{}
+			limiter.SetConnectionPool(poolMock)
+			limiter.Configure(
+				ratelimit.Request{Method: http.MethodGet, PathTemplate: "/apa"},
+				func(req *http.Request) ([]ratelimit.Limit, error) {
+					return []ratelimit.Limit{{
+						Key:       req.URL.Path,
+						Requests:  5,
+						Window:    time.Second,
+						Algorithm: algorithm,
+					}}, nil
+				},
+			)
+			r.Use(limiter.Middleware())
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			poolMock.AssertExpectations(t)
+			connMock.AssertExpectations(t)
+
+			if allowed {
+				require.Equal(t, http.StatusOK, resp.Code)
+			} else {
+				require.Equal(t, http.StatusTooManyRequests, resp.Code)
+			}
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		count      int
+		ttl        time.Duration
+		take       ratelimit.Result
+		code       int
+		limit      string
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{
+			name:  "allowed uses the limit with the least remaining requests",
+			count: 3, ttl: 30 * time.Second,
+			take: ratelimit.Result{Allowed: true, Limit: 20, Remaining: 10, Reset: 2 * time.Second},
+			code: http.StatusOK, limit: "5", remaining: "2", reset: "30",
+		},
+		{
+			name:  "rejected by fixed window",
+			count: 6, ttl: 1500 * time.Millisecond,
+			code: http.StatusTooManyRequests, limit: "5", remaining: "0", reset: "2", retryAfter: "2",
+		},
+		{
+			name:  "rejected by token bucket",
+			count: 1, ttl: 30 * time.Second,
+			take: ratelimit.Result{Limit: 20, Reset: 10 * time.Second, RetryAfter: 100 * time.Millisecond},
+			code: http.StatusTooManyRequests, limit: "20", remaining: "0", reset: "10", retryAfter: "1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, r := getRouterAndRequest(t)
+
+			poolMock := &ConnectionPoolMock{}
+			connMock := &ConnectionMock{}
+			poolMock.On("Connect").Return(connMock).Once()
+
+			connMock.On("Incr", mock.Anything, mock.Anything).Return(test.count, test.ttl, nil).Once()
+			connMock.On("Take", mock.Anything, mock.Anything).Return(test.take, nil).Maybe()
+			connMock.On("Close").Return(nil).Once()
+
+			limiter := &ratelimit.Limiter{}
+			limiter.SetConnectionPool(poolMock)
+			limiter.Configure(
+				ratelimit.Request{Method: http.MethodGet, PathTemplate: "/apa"},
+				func(req *http.Request) ([]ratelimit.Limit, error) {
+					return []ratelimit.Limit{
+						{Key: req.URL.Path, Requests: 5},
+						{Key: req.URL.Path, Requests: 20, Algorithm: ratelimit.TokenBucket},
+					}, nil
+				},
+			)
+			r.Use(limiter.Middleware())
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			connMock.AssertExpectations(t)
+
+			require.Equal(t, test.code, resp.Code)
+			require.Equal(t, test.limit, resp.Header().Get(http_model.HeaderRateLimitLimit))
+			require.Equal(t, test.remaining, resp.Header().Get(http_model.HeaderRateLimitRemaining))
+			require.Equal(t, test.reset, resp.Header().Get(http_model.HeaderRateLimitReset))
+			require.Equal(t, test.retryAfter, resp.Header().Get(http_model.HeaderRetryAfter))
+		})
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy ratelimit.FailurePolicy
+		code   int
+	}{
+		{policy: ratelimit.FailOpen, code: http.StatusOK},
+		{policy: ratelimit.FailClosed, code: http.StatusServiceUnavailable},
+	} {
+		req, r := getRouterAndRequest(t)
+
+		poolMock := &ConnectionPoolMock{}
+		connMock := &ConnectionMock{}
+		poolMock.On("Connect").Return(connMock).Once()
+
+		connMock.On("Incr", mock.Anything, mock.Anything).Return(0, time.Duration(0), errors.New("unreachable")).Once()
+		connMock.On("Close").Return(nil).Once()
+
+		limiter := &ratelimit.Limiter{}
+		limiter.SetConnectionPool(poolMock)
+		limiter.Configure(
+			ratelimit.Request{Method: http.MethodGet, PathTemplate: "/apa"},
+			func(req *http.Request) ([]ratelimit.Limit, error) {
+				return []ratelimit.Limit{{Key: req.URL.Path, Requests: 5}}, nil
+			},
+			ratelimit.WithFailurePolicy(test.policy),
+		)
+		r.Use(limiter.Middleware())
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		connMock.AssertExpectations(t)
+		require.Equal(t, test.code, resp.Code)
+	}
+}
+
 func handler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("apa")) //nolint: errcheck
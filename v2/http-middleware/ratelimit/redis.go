@@ -2,63 +2,221 @@ package ratelimit
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
+const (
+	pooledConnections = 10
+
+	dialTimeout  = 1 * time.Second
+	idleTimeout  = 4 * time.Minute
+	readTimeout  = 1 * time.Second
+	writeTimeout = 1 * time.Second
+)
+
+// RedisOption configures the connections of the Redis connection pools.
+type RedisOption func(*redisOptions)
+
+type redisOptions struct {
+	dialOptions         []redis.DialOption
+	sentinelDialOptions []redis.DialOption
+}
+
+// WithRedisTLS connects to Redis, and to the sentinels of a sentinel pool,
+// using TLS.
+func WithRedisTLS(config *tls.Config) RedisOption {
+	return func(o *redisOptions) {
+		o.dialOptions = append(o.dialOptions, redis.DialUseTLS(true), redis.DialTLSConfig(config))
+		o.sentinelDialOptions = append(o.sentinelDialOptions, redis.DialUseTLS(true), redis.DialTLSConfig(config))
+	}
+}
+
+// WithRedisAuth authenticates to Redis with password, and username when
+// using ACLs.
+func WithRedisAuth(username, password string) RedisOption {
+	return func(o *redisOptions) {
+		o.dialOptions = append(o.dialOptions, redis.DialUsername(username), redis.DialPassword(password))
+	}
+}
+
+// WithSentinelAuth authenticates to the sentinels of a sentinel pool.
+func WithSentinelAuth(username, password string) RedisOption {
+	return func(o *redisOptions) {
+		o.sentinelDialOptions = append(o.sentinelDialOptions, redis.DialUsername(username), redis.DialPassword(password))
+	}
+}
+
+func newRedisOptions(opts []RedisOption) redisOptions {
+	timeouts := []redis.DialOption{
+		redis.DialConnectTimeout(dialTimeout),
+		redis.DialReadTimeout(readTimeout),
+		redis.DialWriteTimeout(writeTimeout),
+	}
+
+	o := redisOptions{
+		dialOptions:         append([]redis.DialOption{}, timeouts...),
+		sentinelDialOptions: append([]redis.DialOption{}, timeouts...),
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+func newRedisPool(dial func(ctx context.Context) (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     pooledConnections,
+		IdleTimeout: idleTimeout,
+		DialContext: dial,
+	}
+}
+
 type redisPool struct {
 	pool *redis.Pool
 }
 
+// scriptConn runs script calls on Redis.
+type scriptConn interface {
+	io.Closer
+
+	// run runs calls in as few round trips as possible and returns the
+	// values of each call.
+	run(calls []scriptCall) ([][]int64, error)
+}
+
+// redisConnection checks limits using scripts, on a single node or a
+// cluster depending on conn.
 type redisConnection struct {
+	conn scriptConn
+}
+
+var (
+	_ BatchConnection     = (*redisConnection)(nil)
+	_ SemaphoreConnection = (*redisConnection)(nil)
+)
+
+// nodeConn is a scriptConn for a single Redis node.
+type nodeConn struct {
 	redis.Conn
 }
 
 func (s *redisPool) Connect() Connection {
-	return &redisConnection{s.pool.Get()}
+	return &redisConnection{&nodeConn{s.pool.Get()}}
 }
 
-func (c *redisConnection) Incr(key string) (int, error) {
-	const secondsToExpire = 60
+func (c *nodeConn) run(calls []scriptCall) ([][]int64, error) {
+	values, errs, err := pipeline(c.Conn, calls)
+	if err != nil {
+		return nil, err
+	}
 
-	cnt, err := redis.Int(c.Do("INCR", key))
+	return values, errors.Join(errs...)
+}
+
+func (c *redisConnection) Close() error {
+	return c.conn.Close()
+}
+
+func (c *redisConnection) Incr(key string, expiry time.Duration) (int, time.Duration, error) {
+	values, err := c.conn.run([]scriptCall{newFixedWindowCall(key, expiry)})
 	if err != nil {
-		return -1, err
+		return -1, 0, err
 	}
 
-	_, err = c.Do("EXPIRE", key, secondsToExpire)
+	return parseFixedWindow(values[0])
+}
+
+func (c *redisConnection) Take(limit Limit, now time.Time) (Result, error) {
+	results, err := c.TakeAll([]Limit{limit}, now)
 	if err != nil {
-		return -1, err
+		return Result{}, err
 	}
 
-	return cnt, nil
+	return results[0], nil
 }
 
-func GetRedisPool(address string) ConnectionPool {
-	var (
-		pooledConnections = 10
+// TakeAll checks all limits in a single round trip, or a single round trip
+// per node in a cluster.
+func (c *redisConnection) TakeAll(limits []Limit, now time.Time) ([]Result, error) {
+	results := make([]Result, len(limits))
+	calls := make([]limitCall, 0, len(limits))
+	indexes := make([]int, 0, len(limits))
 
-		dialTimeout  = 1 * time.Second
-		idleTimeout  = 4 * time.Minute
-		readTimeout  = 1 * time.Second
-		writeTimeout = 1 * time.Second
-	)
+	for i, limit := range limits {
+		call, ok, err := newLimitCall(limit, now)
+		if err != nil {
+			return nil, err
+		}
 
-	pool := &redis.Pool{
-		MaxIdle:     pooledConnections,
-		IdleTimeout: idleTimeout,
-		DialContext: func(ctx context.Context) (redis.Conn, error) {
-			return redis.DialContext(
-				ctx,
-				"tcp",
-				address,
-				redis.DialConnectTimeout(dialTimeout),
-				redis.DialReadTimeout(readTimeout),
-				redis.DialWriteTimeout(writeTimeout),
-			)
-		},
+		if !ok {
+			results[i] = deniedResult(limit)
+			continue
+		}
+
+		calls = append(calls, call)
+		indexes = append(indexes, i)
+	}
+
+	if len(calls) == 0 {
+		return results, nil
+	}
+
+	scriptCalls := make([]scriptCall, len(calls))
+	for i, call := range calls {
+		scriptCalls[i] = call.scriptCall
+	}
+
+	values, err := c.conn.run(scriptCalls)
+	if err != nil {
+		return nil, err
 	}
 
+	for i, call := range calls {
+		if results[indexes[i]], err = call.parse(values[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (c *redisConnection) Acquire(key, token string, limit int, expiry time.Duration, now time.Time) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+
+	values, err := c.conn.run([]scriptCall{newAcquireCall(key, token, limit, expiry, now)})
+	if err != nil {
+		return false, err
+	}
+
+	if len(values[0]) != 1 {
+		return false, fmt.Errorf("unexpected script result %v", values[0])
+	}
+
+	return values[0][0] == 1, nil
+}
+
+func (c *redisConnection) Release(key, token string) error {
+	_, err := c.conn.run([]scriptCall{newReleaseCall(key, token)})
+	return err
+}
+
+// GetRedisPool returns a ConnectionPool for the Redis node at address.
+func GetRedisPool(address string, opts ...RedisOption) ConnectionPool {
+	o := newRedisOptions(opts)
+
+	pool := newRedisPool(func(ctx context.Context) (redis.Conn, error) {
+		return redis.DialContext(ctx, "tcp", address, o.dialOptions...)
+	})
+
 	return &redisPool{pool}
 }
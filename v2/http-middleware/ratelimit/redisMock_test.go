@@ -1,6 +1,8 @@
 package ratelimit_test
 
 import (
+	"time"
+
 	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
 
 	"github.com/stretchr/testify/mock"
@@ -24,7 +26,12 @@ func (m *ConnectionMock) Close() error {
 	return args.Error(0)
 }
 
-func (m *ConnectionMock) Incr(key string) (int, error) {
-	args := m.Called(key)
-	return args.Int(0), args.Error(1)
+func (m *ConnectionMock) Incr(key string, expiry time.Duration) (int, time.Duration, error) {
+	args := m.Called(key, expiry)
+	return args.Int(0), args.Get(1).(time.Duration), args.Error(2)
+}
+
+func (m *ConnectionMock) Take(limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	args := m.Called(limit, now)
+	return args.Get(0).(ratelimit.Result), args.Error(1)
 }
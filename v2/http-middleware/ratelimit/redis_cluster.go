@@ -0,0 +1,397 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+const clusterSlots = 16384
+
+// clusterPool is a ConnectionPool for a Redis Cluster. The calls are routed
+// to the node owning the slot of their first key, using a map of the slots
+// that is refreshed when a node redirects a call or can't be reached.
+type clusterPool struct {
+	seeds   []string
+	options redisOptions
+
+	// refreshes coalesces concurrent refreshes of the slots.
+	refreshes singleflight.Group
+
+	lock   sync.RWMutex
+	slots  []slotRange
+	pools  map[string]*redis.Pool
+	nodes  []string
+	loaded bool
+}
+
+type slotRange struct {
+	start, end int
+	address    string
+}
+
+// clusterConn is a scriptConn for a Redis Cluster, holding a connection to
+// each node it has called.
+type clusterConn struct {
+	pool  *clusterPool
+	conns map[string]redis.Conn
+}
+
+// GetRedisClusterPool returns a ConnectionPool for the Redis Cluster with
+// the nodes at seedAddresses. Not all nodes have to be listed, the others
+// are discovered.
+func GetRedisClusterPool(seedAddresses []string, opts ...RedisOption) ConnectionPool {
+	return &clusterPool{
+		seeds:   seedAddresses,
+		options: newRedisOptions(opts),
+		pools:   map[string]*redis.Pool{},
+	}
+}
+
+func (p *clusterPool) Connect() Connection {
+	return &redisConnection{&clusterConn{pool: p, conns: map[string]redis.Conn{}}}
+}
+
+// nodePool returns the pool of the node at address. The lock must be held.
+func (p *clusterPool) nodePool(address string) *redis.Pool {
+	pool, found := p.pools[address]
+	if !found {
+		pool = newRedisPool(func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", address, p.options.dialOptions...)
+		})
+		p.pools[address] = pool
+	}
+
+	return pool
+}
+
+// address returns the address of the node owning the slot of key.
+func (p *clusterPool) address(key string) (string, error) {
+	p.lock.RLock()
+	loaded := p.loaded
+	p.lock.RUnlock()
+
+	if !loaded {
+		if err := p.refresh(); err != nil {
+			return "", err
+		}
+	}
+
+	slot := keySlot(key)
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	i := sort.Search(len(p.slots), func(i int) bool { return p.slots[i].end >= slot })
+	if i == len(p.slots) || p.slots[i].start > slot {
+		return "", fmt.Errorf("slot %d isn't served by any node", slot)
+	}
+
+	return p.slots[i].address, nil
+}
+
+// refresh loads the map of the slots from the first known node answering.
+// Concurrent calls share a single load.
+func (p *clusterPool) refresh() error {
+	_, err, _ := p.refreshes.Do("slots", func() (any, error) {
+		return nil, p.loadAllSlots()
+	})
+
+	return err
+}
+
+func (p *clusterPool) loadAllSlots() error {
+	p.lock.RLock()
+	addresses := append(append([]string{}, p.nodes...), p.seeds...)
+	p.lock.RUnlock()
+
+	var errs []error
+
+	for _, address := range addresses {
+		slots, err := p.loadSlots(address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", address, err))
+			continue
+		}
+
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		p.slots = slots
+		p.loaded = true
+		p.nodes = p.nodes[:0]
+
+		for _, slot := range slots {
+			p.nodes = append(p.nodes, slot.address)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("failed to load cluster slots: %w", errors.Join(errs...))
+}
+
+func (p *clusterPool) loadSlots(address string) ([]slotRange, error) {
+	p.lock.Lock()
+	pool := p.nodePool(address)
+	p.lock.Unlock()
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]slotRange, 0, len(reply))
+
+	for _, entry := range reply {
+		var (
+			slot   slotRange
+			master []interface{}
+		)
+
+		// Each entry is [start, end, [ip, port, ...], replicas...].
+		if _, err = redis.Scan(entry.([]interface{}), &slot.start, &slot.end, &master); err != nil {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS reply: %w", err)
+		}
+
+		var (
+			ip   string
+			port int
+		)
+
+		if _, err = redis.Scan(master, &ip, &port); err != nil {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS reply: %w", err)
+		}
+
+		// An empty ip means the node that was asked.
+		if ip == "" || ip == "?" {
+			ip = host
+		}
+
+		slot.address = net.JoinHostPort(ip, strconv.Itoa(port))
+		slots = append(slots, slot)
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].start < slots[j].start })
+
+	return slots, nil
+}
+
+func (c *clusterConn) conn(address string) redis.Conn {
+	conn, found := c.conns[address]
+	if !found {
+		c.pool.lock.Lock()
+		pool := c.pool.nodePool(address)
+		c.pool.lock.Unlock()
+
+		conn = pool.Get()
+		c.conns[address] = conn
+	}
+
+	return conn
+}
+
+// drop closes the connection to the node at address, so the next call to
+// the node gets a new connection from the pool.
+func (c *clusterConn) drop(address string) {
+	if conn, found := c.conns[address]; found {
+		conn.Close()
+		delete(c.conns, address)
+	}
+}
+
+// run groups calls by node and pipelines them, a round trip per node. Calls
+// redirected by a node haven't been run, so they are retried once, after
+// refreshing the slots when a slot has moved. The calls to a node that can't
+// be reached are retried the same way, since the node may have been
+// replaced by a failover.
+func (c *clusterConn) run(calls []scriptCall) ([][]int64, error) {
+	values := make([][]int64, len(calls))
+	errs := make([]error, len(calls))
+
+	pending := make([]int, len(calls))
+	for i := range calls {
+		pending[i] = i
+	}
+
+	// stale is set when the slots need to be refreshed before retrying.
+	stale := false
+
+	for attempt := 0; attempt < 2 && len(pending) > 0; attempt++ {
+		if stale {
+			if err := c.pool.refresh(); err != nil {
+				return nil, err
+			}
+
+			stale = false
+		}
+
+		byNode := map[string][]int{}
+
+		for _, i := range pending {
+			// A slot being migrated is asked for at the node it's
+			// migrated to, without updating the slots.
+			if address, ok := askAddress(errs[i]); ok {
+				values[i], errs[i] = c.ask(address, calls[i])
+				continue
+			}
+
+			address, err := c.pool.address(calls[i].key())
+			if err != nil {
+				return nil, err
+			}
+
+			byNode[address] = append(byNode[address], i)
+		}
+
+		pending = pending[:0]
+
+		for address, indexes := range byNode {
+			nodeCalls := make([]scriptCall, len(indexes))
+			for j, i := range indexes {
+				nodeCalls[j] = calls[i]
+			}
+
+			nodeValues, nodeErrs, err := pipeline(c.conn(address), nodeCalls)
+			if err != nil {
+				c.drop(address)
+
+				for _, i := range indexes {
+					values[i], errs[i] = nil, fmt.Errorf("node %s: %w", address, err)
+				}
+
+				pending = append(pending, indexes...)
+				stale = true
+
+				continue
+			}
+
+			for j, i := range indexes {
+				values[i], errs[i] = nodeValues[j], nodeErrs[j]
+
+				if isRedirect(nodeErrs[j]) {
+					pending = append(pending, i)
+				}
+
+				stale = stale || isMoved(nodeErrs[j])
+			}
+		}
+	}
+
+	return values, errors.Join(errs...)
+}
+
+// ask runs call at the node at address, after sending ASKING. EVAL is used
+// since a retry after NOSCRIPT wouldn't be preceded by ASKING.
+func (c *clusterConn) ask(address string, call scriptCall) ([]int64, error) {
+	conn := c.conn(address)
+
+	if err := conn.Send("ASKING"); err != nil {
+		return nil, err
+	}
+
+	if err := call.script.Send(conn, call.keysAndArgs...); err != nil {
+		return nil, err
+	}
+
+	// Do flushes the commands and returns the replies.
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	const askReplies = 2
+	if len(replies) != askReplies {
+		return nil, fmt.Errorf("unexpected replies %v", replies)
+	}
+
+	if err, ok := replies[1].(redis.Error); ok {
+		return nil, err
+	}
+
+	return redis.Int64s(replies[1], nil)
+}
+
+func (c *clusterConn) Close() error {
+	errs := make([]error, 0, len(c.conns))
+	for _, conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func isRedirect(err error) bool {
+	_, ask := askAddress(err)
+	return ask || isMoved(err)
+}
+
+func isMoved(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "MOVED ")
+}
+
+// askAddress returns the address of an ASK redirection, formatted as
+// "ASK <slot> <address>".
+func askAddress(err error) (string, bool) {
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) {
+		return "", false
+	}
+
+	fields := strings.Fields(string(redisErr))
+
+	const askFields = 3
+	if len(fields) != askFields || fields[0] != "ASK" {
+		return "", false
+	}
+
+	return fields[2], true
+}
+
+// keySlot returns the cluster slot of key, hashing only the hash tag of the
+// key when it has one.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
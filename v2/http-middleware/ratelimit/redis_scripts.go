@@ -0,0 +1,383 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/SKF/go-utility/v2/uuid"
+)
+
+// fixedWindowScript increments a counter and sets it to expire if it
+// doesn't have an expiry yet, in a single atomic call.
+//
+// KEYS[1]: the counter
+// ARGV: expiry (ms)
+// Returns {count, ttl (ms)}
+var fixedWindowScript = redis.NewScript(1, `
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+
+return {count, ttl}
+`)
+
+// The limit scripts get the current time from the caller rather than using
+// TIME, since scripts calling TIME can't be replicated verbatim on older
+// Redis versions. They all return
+// {allowed, remaining, reset (ms), retry after (ms)}.
+
+// slidingWindowLogScript stores the time of each allowed request in a sorted
+// set and counts the entries within the last window.
+//
+// KEYS[1]: the sorted set
+// ARGV: now (ms), window (ms), requests, unique member
+var slidingWindowLogScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local requests = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+
+if count < requests then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = 0
+local retry = 0
+
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #newest > 0 then
+	reset = tonumber(newest[2]) + window - now
+end
+
+if allowed == 0 then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	if #oldest > 0 then
+		retry = tonumber(oldest[2]) + window - now
+	end
+end
+
+return {allowed, math.max(0, requests - count), reset, retry}
+`)
+
+// slidingWindowCounterScript weights the count of the previous fixed window
+// by how much of it overlaps the last window.
+//
+// KEYS[1]: the counter of the current window
+// KEYS[2]: the counter of the previous window
+// ARGV: now (ms), window (ms), requests
+var slidingWindowCounterScript = redis.NewScript(2, `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local requests = tonumber(ARGV[3])
+
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local elapsed = now % window
+local weighted = previous * (window - elapsed) / window + current
+local allowed = 0
+
+if weighted < requests then
+	current = redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], 2 * window)
+	weighted = weighted + 1
+	allowed = 1
+end
+
+local reset = 0
+if current > 0 then
+	reset = 2 * window - elapsed
+elseif previous > 0 then
+	reset = window - elapsed
+end
+
+-- The weight of the previous window decreases until the next window,
+-- where the current window becomes the previous one.
+local retry = 0
+if allowed == 0 then
+	retry = window - elapsed
+	if current < requests and previous > 0 then
+		retry = math.min(retry, math.floor((weighted - requests) * window / previous) + 1)
+	end
+end
+
+return {allowed, math.max(0, math.floor(requests - weighted)), reset, retry}
+`)
+
+// tokenBucketScript stores the number of tokens and the time they were
+// counted in a hash.
+//
+// KEYS[1]: the hash
+// ARGV: now (ms), window (ms), requests, burst
+var tokenBucketScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local requests = tonumber(ARGV[3])
+local burst = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * requests / window)
+
+local allowed = 0
+local retry = 0
+
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * window / requests)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', math.max(now, ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * window / requests))
+
+return {allowed, math.floor(tokens), math.ceil((burst - tokens) * window / requests), retry}
+`)
+
+// acquireScript holds a slot of a semaphore, stored as a sorted set of
+// tokens scored by the time their slot expires. A token already holding a
+// slot gets its expiry extended.
+//
+// KEYS[1]: the sorted set
+// ARGV: now (ms), token, limit, expiry (ms)
+// Returns {acquired}
+var acquireScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[3])
+local expiry = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+
+if not redis.call('ZSCORE', KEYS[1], ARGV[2]) and redis.call('ZCARD', KEYS[1]) >= limit then
+	return {0}
+end
+
+redis.call('ZADD', KEYS[1], now + expiry, ARGV[2])
+
+if redis.call('PTTL', KEYS[1]) < expiry then
+	redis.call('PEXPIRE', KEYS[1], expiry)
+end
+
+return {1}
+`)
+
+// releaseScript releases the slot of a token.
+//
+// KEYS[1]: the sorted set
+// ARGV: token
+// Returns {released}
+var releaseScript = redis.NewScript(1, `
+return {redis.call('ZREM', KEYS[1], ARGV[1])}
+`)
+
+// scriptCall is a call of a script with its keys and arguments.
+type scriptCall struct {
+	script      *redis.Script
+	keysAndArgs []interface{}
+}
+
+// key returns the first key of the call, used to route it to a cluster
+// node.
+func (c scriptCall) key() string {
+	return c.keysAndArgs[0].(string)
+}
+
+// limitCall is a scriptCall checking a limit.
+type limitCall struct {
+	scriptCall
+	parse func(values []int64) (Result, error)
+}
+
+func newFixedWindowCall(key string, expiry time.Duration) scriptCall {
+	return scriptCall{fixedWindowScript, []interface{}{key, expiry.Milliseconds()}}
+}
+
+func parseFixedWindow(values []int64) (count int, ttl time.Duration, err error) {
+	const fixedWindowValues = 2
+	if len(values) != fixedWindowValues {
+		return -1, 0, fmt.Errorf("unexpected script result %v", values)
+	}
+
+	return int(values[0]), time.Duration(values[1]) * time.Millisecond, nil
+}
+
+// newLimitCall returns the call checking limit at now. ok is false when the
+// limit can't allow any request, and the call doesn't have to be made.
+func newLimitCall(limit Limit, now time.Time) (_ limitCall, ok bool, _ error) {
+	var (
+		nowMs    = now.UnixMilli()
+		windowMs = limit.Window.Milliseconds()
+		quota    = limit.Requests
+		call     scriptCall
+	)
+
+	if limit.Requests <= 0 || windowMs <= 0 {
+		return limitCall{}, false, nil
+	}
+
+	switch limit.Algorithm {
+	case FixedWindow:
+		key, expiry := fixedWindowKey(limit, now)
+
+		return limitCall{
+			scriptCall: newFixedWindowCall(key, expiry),
+			parse: func(values []int64) (Result, error) {
+				count, ttl, err := parseFixedWindow(values)
+				if err != nil {
+					return Result{}, err
+				}
+
+				return fixedWindowResult(limit, count, ttl), nil
+			},
+		}, true, nil
+	case SlidingWindowLog:
+		call = scriptCall{slidingWindowLogScript, []interface{}{
+			hashTag(limit.Key) + ":log",
+			nowMs, windowMs, limit.Requests, uuid.New().String(),
+		}}
+	case SlidingWindowCounter:
+		index := nowMs / windowMs
+		call = scriptCall{slidingWindowCounterScript, []interface{}{
+			fmt.Sprintf("%s:%d", hashTag(limit.Key), index),
+			fmt.Sprintf("%s:%d", hashTag(limit.Key), index-1),
+			nowMs, windowMs, limit.Requests,
+		}}
+	case TokenBucket:
+		quota = limit.Burst
+		call = scriptCall{tokenBucketScript, []interface{}{
+			hashTag(limit.Key) + ":bucket",
+			nowMs, windowMs, limit.Requests, limit.Burst,
+		}}
+	default:
+		return limitCall{}, false, fmt.Errorf("unsupported algorithm %d", limit.Algorithm)
+	}
+
+	return limitCall{
+		scriptCall: call,
+		parse: func(values []int64) (Result, error) {
+			return parseScriptResult(quota, values)
+		},
+	}, true, nil
+}
+
+// hashTag returns the hash tag keeping the keys of key in the same cluster
+// slot. The key is hashed, as the braces of the path templates in the keys
+// of policies would end the tag.
+func hashTag(key string) string {
+	return "{" + HashKey(key) + "}"
+}
+
+func semaphoreKey(key string) string {
+	return hashTag(key) + ":inflight"
+}
+
+func newAcquireCall(key, token string, limit int, expiry time.Duration, now time.Time) scriptCall {
+	return scriptCall{acquireScript, []interface{}{
+		semaphoreKey(key), now.UnixMilli(), token, limit, expiry.Milliseconds(),
+	}}
+}
+
+func newReleaseCall(key, token string) scriptCall {
+	return scriptCall{releaseScript, []interface{}{semaphoreKey(key), token}}
+}
+
+// deniedResult is the Result of a limit not allowing any request.
+func deniedResult(limit Limit) Result {
+	return Result{RetryAfter: limit.Window, Reset: limit.Window}
+}
+
+func parseScriptResult(quota int, values []int64) (Result, error) {
+	const resultValues = 4
+	if len(values) != resultValues {
+		return Result{}, fmt.Errorf("unexpected script result %v", values)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      quota,
+		Remaining:  int(values[1]),
+		Reset:      time.Duration(values[2]) * time.Millisecond,
+		RetryAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// pipeline sends calls to conn in a single round trip and returns the
+// values or the error of each call. The calls are sent with EVALSHA, and
+// the calls failing because the script isn't cached by Redis are retried,
+// since they haven't been run. err is only set when the round trip fails.
+func pipeline(conn redis.Conn, calls []scriptCall) (values [][]int64, errs []error, err error) {
+	for _, call := range calls {
+		if err = call.script.SendHash(conn, call.keysAndArgs...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err = conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	values = make([][]int64, len(calls))
+	errs = make([]error, len(calls))
+
+	for i := range calls {
+		values[i], errs[i] = redis.Int64s(conn.Receive())
+
+		if conn.Err() != nil {
+			return nil, nil, conn.Err()
+		}
+	}
+
+	// Load the scripts Redis doesn't have cached, again in a single round
+	// trip.
+	var retries []int
+
+	for i, call := range calls {
+		if isNoScript(errs[i]) {
+			if err = call.script.Send(conn, call.keysAndArgs...); err != nil {
+				return nil, nil, err
+			}
+
+			retries = append(retries, i)
+		}
+	}
+
+	if len(retries) == 0 {
+		return values, errs, nil
+	}
+
+	if err = conn.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, i := range retries {
+		values[i], errs[i] = redis.Int64s(conn.Receive())
+
+		if conn.Err() != nil {
+			return nil, nil, conn.Err()
+		}
+	}
+
+	return values, errs, nil
+}
+
+func isNoScript(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT")
+}
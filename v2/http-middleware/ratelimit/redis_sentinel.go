@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// roleCheckInterval is how long a pooled connection can be idle before it's
+// checked to still be connected to the master.
+const roleCheckInterval = 10 * time.Second
+
+// GetRedisSentinelPool returns a ConnectionPool for the master masterName
+// monitored by the Redis sentinels at sentinelAddresses. The address of the
+// master is resolved for each new connection, and pooled connections to a
+// node that is no longer master are discarded, to follow failovers.
+func GetRedisSentinelPool(sentinelAddresses []string, masterName string, opts ...RedisOption) ConnectionPool {
+	o := newRedisOptions(opts)
+
+	pool := newRedisPool(func(ctx context.Context) (redis.Conn, error) {
+		address, err := resolveMaster(ctx, sentinelAddresses, masterName, o.sentinelDialOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		return redis.DialContext(ctx, "tcp", address, o.dialOptions...)
+	})
+
+	pool.TestOnBorrow = func(conn redis.Conn, lastUsed time.Time) error {
+		if time.Since(lastUsed) < roleCheckInterval {
+			return nil
+		}
+
+		role, err := redis.Values(conn.Do("ROLE"))
+		if err != nil {
+			return err
+		}
+
+		if len(role) == 0 {
+			return errors.New("empty ROLE reply")
+		}
+
+		if name, _ := redis.String(role[0], nil); name != "master" {
+			return fmt.Errorf("connected to a %s instead of the master", name)
+		}
+
+		return nil
+	}
+
+	return &redisPool{pool}
+}
+
+// resolveMaster asks the sentinels in turn for the address of the master.
+func resolveMaster(ctx context.Context, sentinelAddresses []string, masterName string, dialOptions []redis.DialOption) (string, error) {
+	var errs []error
+
+	for _, sentinelAddress := range sentinelAddresses {
+		address, err := askSentinel(ctx, sentinelAddress, masterName, dialOptions)
+		if err == nil {
+			return address, nil
+		}
+
+		errs = append(errs, fmt.Errorf("sentinel %s: %w", sentinelAddress, err))
+	}
+
+	return "", fmt.Errorf("failed to resolve master %s: %w", masterName, errors.Join(errs...))
+}
+
+func askSentinel(ctx context.Context, sentinelAddress, masterName string, dialOptions []redis.DialOption) (string, error) {
+	conn, err := redis.DialContext(ctx, "tcp", sentinelAddress, dialOptions...)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	hostAndPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", masterName))
+	if err != nil {
+		return "", err
+	}
+
+	const hostAndPortValues = 2
+	if len(hostAndPort) != hostAndPortValues {
+		return "", fmt.Errorf("unknown master %s", masterName)
+	}
+
+	return net.JoinHostPort(hostAndPort[0], hostAndPort[1]), nil
+}
@@ -0,0 +1,552 @@
+package ratelimit_test
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint: gosec
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/http-middleware/ratelimit"
+)
+
+// fakeRedis is a Redis server speaking enough RESP to test the connection
+// pools. Scripts are recognized by their source rather than run, and reply
+// with the values returned by the scripts reply func.
+type fakeRedis struct {
+	t        *testing.T
+	listener net.Listener
+	handle   func(args []string) interface{}
+
+	lock     sync.Mutex
+	commands [][]string
+	scripts  map[string]string
+	conns    []net.Conn
+}
+
+// redisError is written as a RESP error.
+type redisError string
+
+func newFakeRedis(t *testing.T, handle func(args []string) interface{}) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{t: t, listener: listener, handle: handle, scripts: map[string]string{}}
+	t.Cleanup(func() { listener.Close() })
+
+	go f.serve()
+
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) host() (string, string) {
+	host, port, _ := net.SplitHostPort(f.addr())
+	return host, port
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.serveConn(conn)
+	}
+}
+
+// stop stops the server and closes its connections, like a node going
+// down.
+func (f *fakeRedis) stop() {
+	f.listener.Close()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	f.lock.Lock()
+	f.conns = append(f.conns, conn)
+	f.lock.Unlock()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		if _, err = conn.Write(encodeReply(f.reply(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) reply(args []string) interface{} {
+	f.lock.Lock()
+	f.commands = append(f.commands, args)
+	f.lock.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[1])) //nolint: gosec
+
+		f.lock.Lock()
+		f.scripts[hex.EncodeToString(sum[:])] = args[1]
+		f.lock.Unlock()
+	case "EVALSHA":
+		f.lock.Lock()
+		source, found := f.scripts[args[1]]
+		f.lock.Unlock()
+
+		if !found {
+			return redisError("NOSCRIPT No matching script. Please use EVAL.")
+		}
+
+		args = append([]string{"EVAL", source}, args[2:]...)
+	}
+
+	return f.handle(args)
+}
+
+// commandNames returns the names of the commands received.
+func (f *fakeRedis) commandNames() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	names := make([]string, 0, len(f.commands))
+	for _, command := range f.commands {
+		names = append(names, strings.ToUpper(command[0]))
+	}
+
+	return names
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+
+		length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, length+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(arg[:length])
+	}
+
+	return args, nil
+}
+
+func encodeReply(reply interface{}) []byte {
+	switch reply := reply.(type) {
+	case nil:
+		return []byte("$-1\r\n")
+	case redisError:
+		return []byte("-" + string(reply) + "\r\n")
+	case int:
+		return []byte(fmt.Sprintf(":%d\r\n", reply))
+	case string:
+		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(reply), reply))
+	case []interface{}:
+		encoded := []byte(fmt.Sprintf("*%d\r\n", len(reply)))
+		for _, element := range reply {
+			encoded = append(encoded, encodeReply(element)...)
+		}
+
+		return encoded
+	default:
+		panic(fmt.Sprintf("unsupported reply %T", reply))
+	}
+}
+
+// scriptReply replies to the rate limit scripts with a count of 1 for the
+// fixed window and an allowed request for the others, and to the semaphore
+// scripts with an acquired or released slot.
+func scriptReply(args []string) interface{} {
+	switch {
+	case strings.ToUpper(args[0]) != "EVAL":
+		return "OK"
+	case strings.Contains(args[1], "local count = redis.call('INCR'"):
+		return []interface{}{1, 60000}
+	case strings.Contains(args[1], "'ZSCORE'"), strings.Contains(args[1], "'ZREM'"):
+		return []interface{}{1}
+	default:
+		return []interface{}{1, 4, 1000, 0}
+	}
+}
+
+var testLimits = []ratelimit.Limit{
+	{Key: "user-1", Requests: 5, Window: time.Minute, Algorithm: ratelimit.FixedWindow, Burst: 5},
+	{Key: "user-2", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5},
+}
+
+func TestRedisTakeAllPipelines(t *testing.T) {
+	server := newFakeRedis(t, scriptReply)
+
+	conn := ratelimit.GetRedisPool(server.addr()).Connect()
+	defer conn.Close()
+
+	batch, ok := conn.(ratelimit.BatchConnection)
+	require.True(t, ok)
+
+	results, err := batch.TakeAll(testLimits, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []ratelimit.Result{
+		{Allowed: true, Limit: 5, Remaining: 4, Reset: time.Minute},
+		{Allowed: true, Limit: 5, Remaining: 4, Reset: time.Second},
+	}, results)
+
+	// The scripts aren't cached on the first call.
+	assert.Equal(t, []string{"EVALSHA", "EVALSHA", "EVAL", "EVAL"}, server.commandNames())
+
+	_, err = batch.TakeAll(testLimits, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EVALSHA", "EVALSHA", "EVAL", "EVAL", "EVALSHA", "EVALSHA"}, server.commandNames())
+
+	count, ttl, err := conn.Incr("key", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, time.Minute, ttl)
+}
+
+func TestRedisSemaphore(t *testing.T) {
+	server := newFakeRedis(t, scriptReply)
+
+	conn := ratelimit.GetRedisPool(server.addr()).Connect().(ratelimit.SemaphoreConnection)
+	defer conn.Close()
+
+	acquired, err := conn.Acquire("user-1", "token", 2, time.Minute, time.UnixMilli(1000))
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	require.NoError(t, conn.Release("user-1", "token"))
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	require.Len(t, server.commands, 4)
+	key := "{" + ratelimit.HashKey("user-1") + "}:inflight"
+	assert.Equal(t, []string{"1", key, "1000", "token", "2", "60000"}, server.commands[1][2:])
+	assert.Equal(t, []string{"1", key, "token"}, server.commands[3][2:])
+}
+
+func TestRedisSentinelPool(t *testing.T) {
+	master := newFakeRedis(t, scriptReply)
+	masterHost, masterPort := master.host()
+
+	sentinel := newFakeRedis(t, func(args []string) interface{} {
+		if len(args) == 3 && args[0] == "SENTINEL" && args[2] == "mymaster" {
+			return []interface{}{masterHost, masterPort}
+		}
+
+		return redisError("ERR unknown master")
+	})
+
+	unreachable := "127.0.0.1:1"
+
+	pool := ratelimit.GetRedisSentinelPool(
+		[]string{unreachable, sentinel.addr()}, "mymaster",
+		ratelimit.WithRedisAuth("user", "secret"),
+	)
+
+	conn := pool.Connect()
+	defer conn.Close()
+
+	count, _, err := conn.Incr("key", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.Equal(t, []string{"SENTINEL"}, sentinel.commandNames())
+	assert.Equal(t, []string{"AUTH", "EVALSHA", "EVAL"}, master.commandNames())
+	assert.Equal(t, []string{"AUTH", "user", "secret"}, master.commands[0])
+
+	_, _, err = ratelimit.GetRedisSentinelPool([]string{sentinel.addr()}, "other").Connect().Incr("key", time.Minute)
+	require.ErrorContains(t, err, "unknown master")
+}
+
+func TestRedisClusterPool(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		moved bool
+		nodeA *fakeRedis
+		nodeB *fakeRedis
+	)
+
+	// The bucket of user-1 is in slot 15421 and the bucket of user-2 in
+	// slot 5261.
+	slots := func() interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+
+		hostA, portA := nodeA.host()
+		_, portB := nodeB.host()
+		portAInt, _ := strconv.Atoi(portA)
+		portBInt, _ := strconv.Atoi(portB)
+
+		if !moved {
+			return []interface{}{
+				[]interface{}{0, 16383, []interface{}{hostA, portAInt, "a"}},
+			}
+		}
+
+		return []interface{}{
+			[]interface{}{8192, 16383, []interface{}{"", portBInt, "b"}},
+			[]interface{}{0, 8191, []interface{}{hostA, portAInt, "a"}},
+		}
+	}
+
+	nodeB = newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			return slots()
+		}
+
+		return scriptReply(args)
+	})
+
+	nodeA = newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			return slots()
+		}
+
+		// The slot of user-1 has moved to node B.
+		if args[0] == "EVAL" && args[3] == "{"+ratelimit.HashKey("user-1")+"}:bucket" {
+			lock.Lock()
+			moved = true
+			lock.Unlock()
+
+			return redisError("MOVED 15421 " + nodeB.addr())
+		}
+
+		return scriptReply(args)
+	})
+
+	// Token buckets are used since their keys, unlike the keys of fixed
+	// windows, don't depend on the time.
+	limits := []ratelimit.Limit{
+		{Key: "user-1", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5},
+		{Key: "user-2", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5},
+	}
+
+	conn := ratelimit.GetRedisClusterPool([]string{nodeA.addr()}).Connect()
+	defer conn.Close()
+
+	results, err := conn.(ratelimit.BatchConnection).TakeAll(limits, time.Now())
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Allowed)
+	assert.True(t, results[1].Allowed)
+
+	assert.Equal(t, []string{"CLUSTER", "EVALSHA", "EVALSHA", "EVAL", "EVAL", "CLUSTER"}, nodeA.commandNames())
+	assert.Equal(t, []string{"EVALSHA", "EVAL"}, nodeB.commandNames())
+
+	// The bucket of user-2 stays on node A, the bucket of user-1 goes
+	// directly to node B.
+	_, err = conn.(ratelimit.BatchConnection).TakeAll(limits, time.Now())
+	require.NoError(t, err)
+	assert.Len(t, nodeA.commandNames(), 7)
+	assert.Len(t, nodeB.commandNames(), 3)
+}
+
+func TestRedisClusterPoolHashesTemplateKeys(t *testing.T) {
+	var nodeA, nodeB *fakeRedis
+
+	// The keys of policies embed the path template of their route, the
+	// braces of which don't end up in the hash tags.
+	slots := func() interface{} {
+		hostA, portA := nodeA.host()
+		hostB, portB := nodeB.host()
+		portAInt, _ := strconv.Atoi(portA)
+		portBInt, _ := strconv.Atoi(portB)
+
+		return []interface{}{
+			[]interface{}{0, 8191, []interface{}{hostA, portAInt, "a"}},
+			[]interface{}{8192, 16383, []interface{}{hostB, portBInt, "b"}},
+		}
+	}
+
+	handle := func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			return slots()
+		}
+
+		return scriptReply(args)
+	}
+
+	nodeA = newFakeRedis(t, handle)
+	nodeB = newFakeRedis(t, handle)
+
+	// The bucket of user-1 is in slot 10061 and the bucket of user-2 in
+	// slot 2923.
+	limits := []ratelimit.Limit{
+		{Key: "GET /reports/{id} token-bucket/1s[user-1]:", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5},
+		{Key: "GET /reports/{id} token-bucket/1s[user-2]:", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5},
+	}
+
+	conn := ratelimit.GetRedisClusterPool([]string{nodeA.addr()}).Connect()
+	defer conn.Close()
+
+	_, err := conn.(ratelimit.BatchConnection).TakeAll(limits, time.Now())
+	require.NoError(t, err)
+
+	keys := func(node *fakeRedis) (keys []string) {
+		node.lock.Lock()
+		defer node.lock.Unlock()
+
+		for _, command := range node.commands {
+			if command[0] == "EVAL" {
+				keys = append(keys, command[3])
+			}
+		}
+
+		return keys
+	}
+
+	assert.Equal(t, []string{"{" + ratelimit.HashKey(limits[1].Key) + "}:bucket"}, keys(nodeA))
+	assert.Equal(t, []string{"{" + ratelimit.HashKey(limits[0].Key) + "}:bucket"}, keys(nodeB))
+}
+
+// clusterSlots returns a CLUSTER SLOTS reply of node owning all slots.
+func clusterSlots(node *fakeRedis) interface{} {
+	host, port := node.host()
+	portInt, _ := strconv.Atoi(port)
+
+	return []interface{}{
+		[]interface{}{0, 16383, []interface{}{host, portInt, "id"}},
+	}
+}
+
+func TestRedisClusterPoolFailover(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		replica *fakeRedis
+		primary *fakeRedis
+		owner   *fakeRedis
+	)
+
+	handle := func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return clusterSlots(owner)
+		}
+
+		return scriptReply(args)
+	}
+
+	primary = newFakeRedis(t, handle)
+	replica = newFakeRedis(t, handle)
+	owner = primary
+
+	limit := ratelimit.Limit{Key: "user-1", Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket, Burst: 5}
+
+	pool := ratelimit.GetRedisClusterPool([]string{primary.addr(), replica.addr()})
+
+	take := func() error {
+		conn := pool.Connect()
+		defer conn.Close()
+
+		_, err := conn.Take(limit, time.Now())
+
+		return err
+	}
+
+	require.NoError(t, take())
+
+	// The replica is promoted when the primary goes down. The call to the
+	// primary fails, the slots are refreshed from the replica and the call
+	// is retried there.
+	lock.Lock()
+	owner = replica
+	lock.Unlock()
+
+	primary.stop()
+
+	require.NoError(t, take())
+	assert.Equal(t, []string{"CLUSTER", "EVALSHA", "EVAL"}, replica.commandNames())
+}
+
+func TestRedisClusterPoolCoalescesRefreshes(t *testing.T) {
+	var node *fakeRedis
+
+	node = newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			time.Sleep(50 * time.Millisecond)
+			return clusterSlots(node)
+		}
+
+		return scriptReply(args)
+	})
+
+	pool := ratelimit.GetRedisClusterPool([]string{node.addr()})
+
+	var wg sync.WaitGroup
+
+	for i := range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			conn := pool.Connect()
+			defer conn.Close()
+
+			limit := ratelimit.Limit{Key: fmt.Sprintf("user-%d", i), Requests: 5, Window: time.Second, Algorithm: ratelimit.TokenBucket}
+			_, err := conn.Take(limit, time.Now())
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	clusterCalls := 0
+
+	for _, name := range node.commandNames() {
+		if name == "CLUSTER" {
+			clusterCalls++
+		}
+	}
+
+	assert.Equal(t, 1, clusterCalls)
+}
@@ -12,12 +12,11 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	"github.com/SKF/go-utility/v2/accesstokensubcontext"
 	"github.com/SKF/go-utility/v2/auth"
+	"github.com/SKF/go-utility/v2/claimscontext"
 	"github.com/SKF/go-utility/v2/http-middleware/util"
 	http_model "github.com/SKF/go-utility/v2/http-model"
 	http_server "github.com/SKF/go-utility/v2/http-server"
-	"github.com/SKF/go-utility/v2/impersonatercontext"
 	"github.com/SKF/go-utility/v2/jwk"
 	"github.com/SKF/go-utility/v2/jwt"
 	"github.com/SKF/go-utility/v2/log"
@@ -26,8 +25,6 @@ import (
 
 const (
 	HeaderAuthorization = "Authorization"
-	userIDPrefix        = "enlightUserId:"
-	authorIDPrefix      = "authorId:"
 )
 
 type Config struct {
@@ -46,6 +43,14 @@ type ResponseConfig interface {
 	UnauthorizedResponse() []byte
 }
 
+// ForbiddenResponseConfig is a ResponseConfig with a body for requests
+// failing authorization, used when WithRFC6750 is used. Without it the
+// UnauthorizedResponse body is used.
+type ForbiddenResponseConfig interface {
+	ResponseConfig
+	ForbiddenResponse() []byte
+}
+
 func Configure(conf Config) {
 	auth.Configure(auth.Config{Stage: conf.Stage})
 	jwk.Configure(jwk.Config{Stage: conf.Stage})
@@ -59,12 +64,141 @@ func AuthenticateMiddleware(keySetURL string) mux.MiddlewareFunc {
 	return AuthenticateMiddlewareV3()
 }
 
+// SecurityOption configures the security middleware.
+type SecurityOption func(*securityOptions)
+
+type securityOptions struct {
+	keyProvider jwk.KeyProvider
+	registry    *SecurityRegistry
+	strict      bool
+	concurrency int
+	rfc6750     bool
+}
+
+func newSecurityOptions(opts []SecurityOption) securityOptions {
+	options := securityOptions{
+		registry:    defaultRegistry,
+		concurrency: DefaultAuthorizationConcurrency,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+func (o securityOptions) parseOptions() []jwt.ParseOption {
+	if o.keyProvider == nil {
+		return nil
+	}
+
+	return []jwt.ParseOption{jwt.WithKeyProvider(o.keyProvider)}
+}
+
+// WithKeyProvider makes the middleware validate tokens using keys from provider
+// instead of the key sets configured globally in the jwk package.
+func WithKeyProvider(provider jwk.KeyProvider) SecurityOption {
+	return func(o *securityOptions) {
+		o.keyProvider = provider
+	}
+}
+
+// WithSecurityRegistry makes the middleware use the security configurations
+// of registry instead of the ones added by the package level
+// HandleSecureEndpoint functions.
+func WithSecurityRegistry(registry *SecurityRegistry) SecurityOption {
+	return func(o *securityOptions) {
+		o.registry = registry
+	}
+}
+
+// WithStrictMode makes AuthenticateMiddlewareV3 deny requests to routes that
+// aren't explicitly configured as either requiring an access token or as
+// Public, instead of letting them through unauthenticated. OPTIONS requests
+// are always let through, since CORS preflight requests don't carry
+// credentials. Use SecurityRegistry.VerifyRouter at startup to find the
+// routes that would be denied.
+func WithStrictMode() SecurityOption {
+	return func(o *securityOptions) {
+		o.strict = true
+	}
+}
+
+// DefaultAuthorizationConcurrency is the default number of authorizations
+// AuthorizeMiddleware checks concurrently.
+const DefaultAuthorizationConcurrency = 4
+
+// WithAuthorizationConcurrency sets the number of authorizations of a request
+// AuthorizeMiddleware checks concurrently with the Authorizer. Use 1 to check
+// them one at a time.
+func WithAuthorizationConcurrency(concurrency int) SecurityOption {
+	return func(o *securityOptions) {
+		o.concurrency = max(concurrency, 1)
+	}
+}
+
+// WithRFC6750 makes the middleware respond as described in RFC 6750.
+// Requests failing authentication get a WWW-Authenticate header telling
+// whether the token was missing, expired or otherwise invalid. Requests
+// failing authorization get http.StatusForbidden instead of
+// http.StatusUnauthorized, with the ForbiddenResponse body when the
+// ResponseConfig is a ForbiddenResponseConfig.
+func WithRFC6750() SecurityOption {
+	return func(o *securityOptions) {
+		o.rfc6750 = true
+	}
+}
+
+// errMissingToken is returned by handleAccessOrIDToken when the request has
+// no token.
+var errMissingToken = errors.New("missing token")
+
+// bearerChallenge returns the RFC 6750 WWW-Authenticate challenge for a
+// request failing authentication with err.
+func bearerChallenge(err error) string {
+	switch {
+	case errors.Is(err, errMissingToken):
+		return `Bearer`
+	case errors.Is(err, jwt.ErrNotValidNow{}):
+		return `Bearer error="invalid_token", error_description="The access token expired or is not yet valid"`
+	default:
+		return `Bearer error="invalid_token", error_description="The access token is invalid"`
+	}
+}
+
+func (o securityOptions) writeUnauthenticated(ctx context.Context, w http.ResponseWriter, req *http.Request, secConfig SecurityConfig, err error) {
+	if o.rfc6750 {
+		w.Header().Set(http_model.HeaderWWWAuthenticate, bearerChallenge(err))
+	}
+
+	responseBody := GetUnauthenticedErrorResponseBody(http_model.ErrResponseUnauthorized, secConfig)
+	writeAndLogResponse(ctx, w, req, http.StatusUnauthorized, responseBody)
+}
+
+func (o securityOptions) writeUnauthorized(ctx context.Context, w http.ResponseWriter, req *http.Request, secConfig SecurityConfig) {
+	if !o.rfc6750 {
+		responseBody := GetUnauthorizedErrorResponseBody(http_model.ErrResponseUnauthorized, secConfig)
+		writeAndLogResponse(ctx, w, req, http.StatusUnauthorized, responseBody)
+
+		return
+	}
+
+	w.Header().Set(http_model.HeaderWWWAuthenticate, `Bearer error="insufficient_scope"`)
+
+	responseBody := GetForbiddenErrorResponseBody(http_model.ErrResponseForbidden, secConfig)
+	writeAndLogResponse(ctx, w, req, http.StatusForbidden, responseBody)
+}
+
 // AuthenticateMiddlewareV3 retrieves the security configuration for the matched route
 // and handles Access Token validation and stores the token claims in the request context.
-func AuthenticateMiddlewareV3() mux.MiddlewareFunc {
-	if err := jwk.RefreshKeySets(); err != nil {
-		log.WithError(err).
-			Error("Couldn't refresh JWKeySets")
+func AuthenticateMiddlewareV3(opts ...SecurityOption) mux.MiddlewareFunc {
+	options := newSecurityOptions(opts)
+
+	if options.keyProvider == nil {
+		if err := jwk.RefreshKeySets(); err != nil {
+			log.WithError(err).
+				Error("Couldn't refresh JWKeySets")
+		}
 	}
 
 	return func(next http.Handler) http.Handler {
@@ -72,12 +206,15 @@ func AuthenticateMiddlewareV3() mux.MiddlewareFunc {
 			ctx, span := util.StartSpanNoRoot(req.Context(), "AuthenticateMiddlewareV3/Handler")
 			defer span.End()
 
-			secConfig := lookupSecurityConfig(req)
-			if secConfig.accessTokenHeader != "" {
-				if err := handleAccessOrIDToken(ctx, req, secConfig.accessTokenHeader); err != nil {
-					responseBody := GetUnauthenticedErrorResponseBody(http_model.ErrResponseUnauthorized, secConfig)
-					writeAndLogResponse(ctx, w, req, http.StatusUnauthorized, responseBody)
+			secConfig, found := options.registry.lookup(req)
+			if options.strict && !isExplicitlyConfigured(req, secConfig, found) {
+				options.writeUnauthenticated(ctx, w, req, secConfig, errMissingToken)
+				return
+			}
 
+			if secConfig.accessTokenHeader != "" {
+				if err := handleAccessOrIDToken(ctx, req, secConfig.accessTokenHeader, options.parseOptions()...); err != nil {
+					options.writeUnauthenticated(ctx, w, req, secConfig, err)
 					return
 				}
 			}
@@ -88,87 +225,60 @@ func AuthenticateMiddlewareV3() mux.MiddlewareFunc {
 	}
 }
 
-//nolint:gocyclo
-func handleAccessOrIDToken(ctx context.Context, req *http.Request, header string) error {
+func isExplicitlyConfigured(req *http.Request, secConfig SecurityConfig, found bool) bool {
+	if req.Method == http.MethodOptions {
+		return true
+	}
+
+	return found && (secConfig.public || secConfig.accessTokenHeader != "")
+}
+
+func handleAccessOrIDToken(ctx context.Context, req *http.Request, header string, parseOpts ...jwt.ParseOption) error {
 	base64Token := req.Header.Get(header)
 	if base64Token == "" {
-		return fmt.Errorf("auth header [%s] was empty", header)
+		return fmt.Errorf("auth header [%s] was empty: %w", header, errMissingToken)
 	}
 
-	token, err := jwt.Parse(base64Token)
+	token, err := jwt.Parse(base64Token, parseOpts...)
 	if err != nil {
 		return fmt.Errorf("authorization token not valid: %w", err)
 	}
 
-	// we need author ID for impersonation to log properly in micro services.
 	claims := token.GetClaims()
-	userID, authorID := resolveUserAndAuthor(claims)
-
 	if claims.TokenUse != jwt.TokenUseID && claims.TokenUse != jwt.TokenUseAccess {
 		return fmt.Errorf("invalid token use %s", claims.TokenUse)
 	}
 
-	ctx = accesstokensubcontext.NewContext(ctx, claims.Subject)
-	ctx = useridcontext.NewContext(ctx, userID)
-	ctx = impersonatercontext.NewContext(ctx, authorID)
-	*req = *req.WithContext(ctx)
+	// The claims include the author ID needed for impersonation to log
+	// properly in micro services.
+	*req = *req.WithContext(claimscontext.NewContext(ctx, claims, base64Token))
 
 	return nil
 }
 
-// userID is the Enlight User ID of the authenticated/impersonated user.
-// authorID is the Enlight User ID of the authenticated user who creates the token.
-// If token is generated for impersonation, author indicates the admin user who wants to impersonate.
-// If it is a normal token, authorID and userID are the same.
-// We added these two fields to all the tokens to make sure that it will be consistent between the services.
-func resolveUserAndAuthor(claims jwt.Claims) (userID string, authorID string) {
-	cognitoGroups := claims.CognitoGroups
-
-	for _, group := range cognitoGroups {
-		if strings.HasPrefix(group, userIDPrefix) {
-			if len(group) == len(userIDPrefix) { // nothing after the prefix
-				continue
-			}
-
-			result := group[len(userIDPrefix):]
-
-			userID = result
-		}
-
-		if strings.HasPrefix(group, authorIDPrefix) {
-			if len(group) == len(authorIDPrefix) { // nothing after the prefix
-				continue
-			}
-
-			result := group[len(authorIDPrefix):]
-
-			authorID = result
-		}
-	}
-
-	return
-}
-
 type Authorizer interface {
 	IsAuthorizedWithContext(ctx context.Context, userID, action string, resource *common.Origin) (bool, error)
 }
 
 // AuthorizeMiddleware retrieves the security configuration for the matched
-// route and handles the configured authorizations. If any of the configured
-// ResourceFuncs returns a HTTPError or an error wrapping a HTTPError, the error
-// code and message from that error is written. Other errors from the
-// ResourceFuncs results in a http.StatusInternalServerError response being
-// written. If the request fails the authorization check,
-// http.StatusUnauthorized is returned to the client.
-func AuthorizeMiddleware(authorizer Authorizer) mux.MiddlewareFunc {
+// route and handles the configured claim requirements and authorizations. If
+// any of the configured ResourceFuncs returns a HTTPError or an error wrapping
+// a HTTPError, the error code and message from that error is written. Other
+// errors from the ResourceFuncs results in a http.StatusInternalServerError
+// response being written. If the request fails the authorization check,
+// http.StatusUnauthorized is returned to the client, or http.StatusForbidden
+// when WithRFC6750 is used.
+func AuthorizeMiddleware(authorizer Authorizer, opts ...SecurityOption) mux.MiddlewareFunc {
+	options := newSecurityOptions(opts)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			ctx, span := util.StartSpanNoRoot(req.Context(), "AuthorizeMiddleware/Handler")
 			defer span.End()
 
 			// If current route doesn't need to be authenicated
-			secConfig := lookupSecurityConfig(req)
-			if len(secConfig.authorizations) == 0 {
+			secConfig, _ := options.registry.lookup(req)
+			if len(secConfig.authorizations) == 0 && len(secConfig.claimRequirements) == 0 {
 				next.ServeHTTP(w, req)
 				return
 			}
@@ -181,7 +291,20 @@ func AuthorizeMiddleware(authorizer Authorizer) mux.MiddlewareFunc {
 				return
 			}
 
-			isAuthorized, err := checkAuthorization(ctx, req, authorizer, userID, secConfig.authorizations)
+			claims, ok := claimscontext.FromContext(ctx)
+			if !ok && len(secConfig.claimRequirements) > 0 {
+				responseBody := GetInternalServerErrorResponseBody(http_model.ErrResponseInternalServerError, secConfig)
+				writeAndLogResponse(ctx, w, req, http.StatusInternalServerError, responseBody)
+
+				return
+			}
+
+			if !checkClaimRequirements(ctx, req, claims, secConfig.claimRequirements) {
+				options.writeUnauthorized(ctx, w, req, secConfig)
+				return
+			}
+
+			isAuthorized, err := checkAuthorization(ctx, req, authorizer, userID, secConfig.authorizations, options.concurrency)
 			var httpErr *http_model.HTTPError
 			if errors.As(err, &httpErr) {
 				if secConfig.responses != nil {
@@ -201,9 +324,7 @@ func AuthorizeMiddleware(authorizer Authorizer) mux.MiddlewareFunc {
 			}
 
 			if !isAuthorized {
-				responseBody := GetUnauthorizedErrorResponseBody(http_model.ErrResponseUnauthorized, secConfig)
-				writeAndLogResponse(ctx, w, req, http.StatusUnauthorized, responseBody)
-
+				options.writeUnauthorized(ctx, w, req, secConfig)
 				return
 			}
 
@@ -226,68 +347,315 @@ func writeAndLogResponse(ctx context.Context, w http.ResponseWriter, r *http.Req
 	http_server.WriteJSONResponse(ctx, w, r, status, body)
 }
 
-func checkAuthorization(ctx context.Context, req *http.Request, authorizer Authorizer, userID string, configuredAuthorizations []authorizationConfig) (bool, error) {
+func checkClaimRequirements(ctx context.Context, req *http.Request, claims jwt.Claims, requirements []claimRequirement) bool {
+	for _, requirement := range requirements {
+		if missing := requirement.missing(claims); len(missing) > 0 {
+			log.
+				WithTracing(ctx).
+				WithUserID(ctx).
+				WithField("method", req.Method).
+				WithField("url", req.URL.String()).
+				WithField("claim", requirement.claim).
+				WithField("missing", missing).
+				Debug("User is not Authorized")
+
+			return false
+		}
+	}
+
+	return true
+}
+
+type authorizationCheck struct {
+	requirement int
+	action      string
+	resource    *common.Origin
+}
+
+type authorizationResult struct {
+	authorizationCheck
+	// index is the index of the authorization in its requirement.
+	index   int
+	allowed bool
+	err     error
+}
+
+type checkState int
+
+const (
+	// checkPending is the state of a check not dispatched or not answered.
+	checkPending checkState = iota
+	checkAllowed
+	checkDenied
+	// checkFailed is the state of a check the Authorizer failed.
+	checkFailed
+	// checkResourceFailed is the state of a check whose ResourceFunc failed.
+	checkResourceFailed
+)
+
+type checkOutcome struct {
+	check authorizationCheck
+	state checkState
+	err   error
+}
+
+// requirementOutcome is the outcome of a requirement. An undecided
+// requirement may be decided by a failed ResourceFunc if mayFail is set.
+type requirementOutcome struct {
+	decided bool
+	met     bool
+	// err is set for a requirement decided by a failed ResourceFunc.
+	err error
+	// authorizerErr is set for a requirement decided by a failed check.
+	authorizerErr error
+	mayFail       bool
+}
+
+// evaluateRequirement returns the outcome of the checks of a requirement as
+// if they were checked one at a time in order: the first allowed check meets
+// the requirement, and a failed ResourceFunc decides it unless a check
+// before it is allowed. Failed checks only decide it when no check is
+// allowed.
+func evaluateRequirement(outcomes []checkOutcome) requirementOutcome {
+	var authorizerErr error
+
+	undecided := false
+
+	for _, outcome := range outcomes {
+		switch outcome.state {
+		case checkAllowed:
+			return requirementOutcome{decided: true, met: true}
+		case checkResourceFailed:
+			if undecided {
+				return requirementOutcome{mayFail: true}
+			}
+
+			return requirementOutcome{decided: true, err: outcome.err}
+		case checkFailed:
+			if authorizerErr == nil {
+				authorizerErr = outcome.err
+			}
+		case checkPending:
+			undecided = true
+		case checkDenied:
+		}
+	}
+
+	if undecided {
+		return requirementOutcome{}
+	}
+
+	return requirementOutcome{decided: true, authorizerErr: authorizerErr}
+}
+
+// checkAuthorization checks that every requirement has an authorized
+// authorization.
+//
+// The ResourceFuncs are called on the request goroutine, one at a time in
+// the configured order, and the authorizations are checked concurrently
+// with at most concurrency checks in flight. The outcome is the same as if
+// the authorizations were checked one at a time in order, and the remaining
+// checks are canceled once it's decided:
+//   - A denied requirement decides the outcome, unless a failed ResourceFunc
+//     of an earlier requirement does.
+//   - A failed ResourceFunc decides the outcome when the requirements before
+//     it are met and no authorization before it in its requirement is
+//     allowed.
+//   - An Authorizer error only decides the outcome when no requirement is
+//     denied and no ResourceFunc failed.
+//
+//nolint:gocyclo
+func checkAuthorization(ctx context.Context, req *http.Request, authorizer Authorizer, userID string, requirements []authorizationRequirement, concurrency int) (bool, error) {
 	logFields := log.
 		WithTracing(ctx).
 		WithUserID(ctx).
 		WithField("method", req.Method).
 		WithField("url", req.URL.String())
 
-	for _, authorizeConfig := range configuredAuthorizations {
-		resource, err := authorizeConfig.resourceFunc(req)
-		if err != nil {
-			return false, err
+	outcomes := make([][]checkOutcome, len(requirements))
+	for i, requirement := range requirements {
+		outcomes[i] = make([]checkOutcome, len(requirement))
+	}
+
+	notAuthorized := func(requirement int) (bool, error) {
+		var actions []string
+
+		var resources []*common.Origin
+
+		for _, outcome := range outcomes[requirement] {
+			if outcome.state != checkPending {
+				actions = append(actions, outcome.check.action)
+				resources = append(resources, outcome.check.resource)
+			}
 		}
 
-		ok, err := authorizer.IsAuthorizedWithContext(
-			ctx,
-			userID,
-			authorizeConfig.action,
-			resource,
-		)
-		if err != nil {
-			if grpcStatus := status.Code(err); grpcStatus == codes.Canceled {
-				return false, nil
+		logFields.
+			WithField("userId", userID).
+			WithField("action", strings.Join(actions, ",")).
+			WithField("resource", resources).
+			Debug("User is not Authorized")
+
+		return false, nil
+	}
+
+	// decide returns the outcome once it can't change anymore.
+	decide := func() (decided, authorized bool, err error) {
+		var authorizerErr error
+
+		undecided := false
+
+		for i := range requirements {
+			outcome := evaluateRequirement(outcomes[i])
+
+			switch {
+			case outcome.met:
+			case outcome.decided && outcome.err != nil:
+				// An undecided earlier requirement may still be denied.
+				if undecided {
+					return false, false, nil
+				}
+
+				return true, false, outcome.err
+			case outcome.decided && outcome.authorizerErr != nil:
+				if authorizerErr == nil {
+					authorizerErr = outcome.authorizerErr
+				}
+			case outcome.decided:
+				authorized, err = notAuthorized(i)
+				return true, authorized, err
+			case outcome.mayFail:
+				return false, false, nil
+			default:
+				undecided = true
 			}
+		}
 
-			return false, err
+		if undecided {
+			return false, false, nil
 		}
 
-		if !ok {
-			logFields.
-				WithField("userId", userID).
-				WithField("action", authorizeConfig.action).
-				WithField("resource", resource).
-				Debug("User is not Authorized")
+		return true, authorizerErr == nil, authorizerErr
+	}
 
-			return false, nil
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// results is buffered for all checks, so no check blocks after the
+	// outcome is decided.
+	results := make(chan authorizationResult, countChecks(requirements))
+	cache, isCache := authorizer.(decisionCache)
+
+	record := func(result authorizationResult) {
+		outcome := &outcomes[result.requirement][result.index]
+		outcome.check = result.authorizationCheck
+
+		switch {
+		case result.err != nil && status.Code(result.err) != codes.Canceled:
+			outcome.state, outcome.err = checkFailed, result.err
+		case result.err == nil && result.allowed:
+			outcome.state = checkAllowed
+		default:
+			outcome.state = checkDenied
 		}
 	}
 
-	return true, nil
-}
-
-var securityConfigurations []*SecurityConfig
+	// skip returns whether the check at index of requirement doesn't have
+	// to be dispatched, as its requirement is met or decided by a failed
+	// ResourceFunc.
+	skip := func(requirement, index int) bool {
+		for _, outcome := range outcomes[requirement][:index] {
+			if outcome.state == checkAllowed || outcome.state == checkResourceFailed {
+				return true
+			}
+		}
 
-func lookupSecurityConfig(req *http.Request) (_ SecurityConfig) {
-	route := mux.CurrentRoute(req)
-	if route == nil {
-		return
+		return false
 	}
 
-	if pathTemplate, err := route.GetPathTemplate(); err == nil {
-		for _, config := range securityConfigurations {
-			if config.endpoint == pathTemplate {
-				for _, method := range config.methods {
-					if method == req.Method {
-						return *config
-					}
+	inFlight := 0
+	requirement, index := 0, 0
+
+	for {
+		if decided, authorized, err := decide(); decided {
+			return authorized, err
+		}
+
+		for requirement < len(requirements) && (index == len(requirements[requirement]) || skip(requirement, index)) {
+			if index == len(requirements[requirement]) {
+				requirement, index = requirement+1, 0
+			} else {
+				index++
+			}
+		}
+
+		if inFlight < concurrency && requirement < len(requirements) {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+
+			authorizeConfig := requirements[requirement][index]
+			resource, err := authorizeConfig.resourceFunc(req)
+			check := authorizationCheck{requirement, authorizeConfig.action, resource}
+			result := authorizationResult{authorizationCheck: check, index: index}
+
+			index++
+
+			switch {
+			case err != nil:
+				outcomes[check.requirement][result.index] = checkOutcome{check, checkResourceFailed, err}
+				continue
+			case isCache:
+				// A cached decision is used right away, so each
+				// authorization is looked up in the cache once.
+				if allowed, found := cache.CachedDecision(userID, check.action, check.resource); found {
+					result.allowed = allowed
+					record(result)
+
+					continue
 				}
 			}
+
+			inFlight++
+
+			go func() {
+				if isCache {
+					result.allowed, result.err = cache.authorize(ctx, userID, check.action, check.resource)
+				} else {
+					result.allowed, result.err = authorizer.IsAuthorizedWithContext(ctx, userID, check.action, check.resource)
+				}
+
+				results <- result
+			}()
+
+			continue
+		}
+
+		if inFlight == 0 {
+			break
 		}
+
+		select {
+		case <-ctx.Done():
+			// The request is canceled, the checks not dispatched will
+			// never be answered.
+			return false, nil
+		case result := <-results:
+			inFlight--
+			record(result)
+		}
+	}
+
+	_, authorized, err := decide()
+
+	return authorized, err
+}
+
+func countChecks(requirements []authorizationRequirement) (count int) {
+	for _, requirement := range requirements {
+		count += len(requirement)
 	}
 
-	return
+	return count
 }
 
 // SecurityConfig represents how to authenticate and authorize a given endpoint and method.
@@ -295,36 +663,79 @@ type SecurityConfig struct {
 	endpoint          string
 	methods           []string
 	accessTokenHeader string
-	authorizations    []authorizationConfig
+	authorizations    []authorizationRequirement
 	responses         ResponseConfig
+	registry          *SecurityRegistry
+	public            bool
+	claimRequirements []claimRequirement
 }
 
+// claimRequirement requires a claim of the validated token to contain all
+// the required values.
+type claimRequirement struct {
+	claim    string
+	required []string
+	values   func(jwt.Claims) []string
+}
+
+func (c claimRequirement) missing(claims jwt.Claims) []string {
+	values := map[string]bool{}
+	for _, value := range c.values(claims) {
+		values[value] = true
+	}
+
+	var missing []string
+
+	for _, required := range c.required {
+		if !values[required] {
+			missing = append(missing, required)
+		}
+	}
+
+	return missing
+}
+
+// authorizationRequirement is met when any of its authorizations is
+// authorized.
+type authorizationRequirement []authorizationConfig
+
 type authorizationConfig struct {
 	action       string
 	resourceFunc ResourceFunc
 }
 
-// HandleSecureEndpoint creates a new SecurityConfig for the specified endpoint.
+// HandleSecureEndpoint creates a new SecurityConfig for the specified endpoint
+// in the default SecurityRegistry.
 func HandleSecureEndpoint(endpoint string) *SecurityConfig {
-	s := &SecurityConfig{endpoint: endpoint}
-	securityConfigurations = append(securityConfigurations, s)
-
-	return s
+	return defaultRegistry.HandleSecureEndpoint(endpoint)
 }
 
 func HandleSecureEndpointCustomErrorResponse(endpoint string, responses ResponseConfig) *SecurityConfig {
-	s := &SecurityConfig{
-		endpoint:  endpoint,
-		responses: responses,
-	}
-	securityConfigurations = append(securityConfigurations, s)
+	return defaultRegistry.HandleSecureEndpointCustomErrorResponse(endpoint, responses)
+}
 
-	return s
+// UnsecuredRoutes returns the routes of router without a security
+// configuration in the default SecurityRegistry.
+func UnsecuredRoutes(router *mux.Router) ([]Route, error) {
+	return defaultRegistry.UnsecuredRoutes(router)
+}
+
+// VerifyRouter returns an error listing the routes of router without a
+// security configuration in the default SecurityRegistry.
+func VerifyRouter(router *mux.Router) error {
+	return defaultRegistry.VerifyRouter(router)
 }
 
 // Methods adds methods to the SecurityConfig.
+// An empty method matches every method, like a route without methods.
 func (s *SecurityConfig) Methods(methods ...string) *SecurityConfig {
-	s.methods = methods
+	if s.registry == nil {
+		s.methods = methods
+		return s
+	}
+
+	s.registry.setMethods(s, methods)
+
 	return s
 }
 
@@ -339,6 +750,14 @@ func (s *SecurityConfig) AccessToken(headers ...string) *SecurityConfig {
 	return s
 }
 
+// Public marks the endpoint as not requiring authentication, which is needed
+// for the endpoint to be reachable when WithStrictMode is used.
+// If AccessToken is also used the access token is still required.
+func (s *SecurityConfig) Public() *SecurityConfig {
+	s.public = true
+	return s
+}
+
 // ResourceFunc takes a *http.Request and returns the resource to use for authorization.
 // If the ResourceFunc fails because of invalid input data or a missing resource,
 // return a HttpError, or an error wrapping a HTTPError.
@@ -375,15 +794,96 @@ var NilResourceFunc = func(_ *http.Request) (*common.Origin, error) {
 }
 
 // Authorize adds an Authorization Configuration to the SecurityConfig.
+// All authorizations added with Authorize and AuthorizeAny are required.
 func (s *SecurityConfig) Authorize(action string, resourceFunc ResourceFunc) *SecurityConfig {
 	s.authorizations = append(
 		s.authorizations,
-		authorizationConfig{action, resourceFunc},
+		authorizationRequirement{{action, resourceFunc}},
+	)
+
+	return s
+}
+
+// Authorization is an action on the resource returned by a ResourceFunc.
+type Authorization struct {
+	Action       string
+	ResourceFunc ResourceFunc
+}
+
+// AuthorizeAny adds a group of authorizations to the SecurityConfig, of which
+// any one is required. The following example requires that the user can
+// either read the asset or administer the company
+//
+//	HandleSecureEndpoint("/companies/{companyID}/assets/{assetID}").
+//	    Methods(http.MethodGet).
+//	    AccessToken().
+//	    AuthorizeAny(
+//	        Authorization{Action: "asset/read", ResourceFunc: assetFromPathFunc},
+//	        Authorization{Action: "company/admin", ResourceFunc: companyFromPathFunc},
+//	    )
+//
+// The authorizations are decided in order, so the error of a ResourceFunc is
+// only written when none of the authorizations before it is allowed.
+func (s *SecurityConfig) AuthorizeAny(authorizations ...Authorization) *SecurityConfig {
+	requirement := make(authorizationRequirement, 0, len(authorizations))
+	for _, authorization := range authorizations {
+		requirement = append(requirement, authorizationConfig{authorization.Action, authorization.ResourceFunc})
+	}
+
+	s.authorizations = append(s.authorizations, requirement)
+
+	return s
+}
+
+// RequireRoles requires the validated token to have all the roles in its
+// comma separated enlightRoles claim.
+//
+// Like the other Require functions it's evaluated by AuthorizeMiddleware
+// from the token claims, without calling the Authorizer, and before the
+// authorizations added with Authorize.
+func (s *SecurityConfig) RequireRoles(roles ...string) *SecurityConfig {
+	return s.require("enlightRoles", roles, func(claims jwt.Claims) []string {
+		return splitList(claims.EnlightRoles)
+	})
+}
+
+// RequireScopes requires the validated token to have all the OAuth scopes
+// in its scope claim. Only access tokens have scopes.
+func (s *SecurityConfig) RequireScopes(scopes ...string) *SecurityConfig {
+	return s.require("scope", scopes, func(claims jwt.Claims) []string {
+		return claims.Scopes()
+	})
+}
+
+// RequireGroups requires the validated token to have all the groups in its
+// cognito:groups claim.
+func (s *SecurityConfig) RequireGroups(groups ...string) *SecurityConfig {
+	return s.require("cognito:groups", groups, func(claims jwt.Claims) []string {
+		return claims.CognitoGroups
+	})
+}
+
+func (s *SecurityConfig) require(claim string, required []string, values func(jwt.Claims) []string) *SecurityConfig {
+	s.claimRequirements = append(
+		s.claimRequirements,
+		claimRequirement{claim, required, values},
 	)
 
 	return s
 }
 
+func splitList(list string) []string {
+	var values []string
+
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
 func GetInternalServerErrorResponseBody(defaultResponse []byte, secConfig SecurityConfig) []byte {
 	responsebody := defaultResponse
 
@@ -413,3 +913,18 @@ func GetUnauthorizedErrorResponseBody(defaultResponse []byte, secConfig Security
 
 	return responsebody
 }
+
+// GetForbiddenErrorResponseBody returns the ForbiddenResponse body of the
+// ResponseConfig of secConfig, falling back to the UnauthorizedResponse body
+// and then to defaultResponse.
+func GetForbiddenErrorResponseBody(defaultResponse []byte, secConfig SecurityConfig) []byte {
+	if responses, ok := secConfig.responses.(ForbiddenResponseConfig); ok && len(responses.ForbiddenResponse()) > 0 {
+		return responses.ForbiddenResponse()
+	}
+
+	if secConfig.responses != nil && len(secConfig.responses.UnauthorizedResponse()) > 0 {
+		return secConfig.responses.UnauthorizedResponse()
+	}
+
+	return defaultResponse
+}
@@ -0,0 +1,253 @@
+package httpmiddleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultRegistry holds the configurations added by the package level
+// HandleSecureEndpoint functions.
+var defaultRegistry = NewSecurityRegistry()
+
+// SecurityRegistry holds the security configurations of the endpoints of a
+// router. Use one registry per router, and pass it to the middleware using
+// WithSecurityRegistry, to keep the configurations of several routers in the
+// same binary apart.
+type SecurityRegistry struct {
+	lock    sync.RWMutex
+	configs []*SecurityConfig
+	index   map[Route]*SecurityConfig
+}
+
+// Route is a method and path template of a mux route.
+type Route struct {
+	Method       string
+	PathTemplate string
+}
+
+func (r Route) String() string {
+	return r.Method + " " + r.PathTemplate
+}
+
+// NewSecurityRegistry creates an empty SecurityRegistry.
+func NewSecurityRegistry() *SecurityRegistry {
+	return &SecurityRegistry{index: map[Route]*SecurityConfig{}}
+}
+
+// HandleSecureEndpoint creates a new SecurityConfig for the specified endpoint.
+func (r *SecurityRegistry) HandleSecureEndpoint(endpoint string) *SecurityConfig {
+	return r.add(&SecurityConfig{endpoint: endpoint})
+}
+
+// HandleSecureEndpointCustomErrorResponse creates a new SecurityConfig for
+// the specified endpoint using responses for the error responses.
+func (r *SecurityRegistry) HandleSecureEndpointCustomErrorResponse(endpoint string, responses ResponseConfig) *SecurityConfig {
+	return r.add(&SecurityConfig{endpoint: endpoint, responses: responses})
+}
+
+func (r *SecurityRegistry) add(s *SecurityConfig) *SecurityConfig {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s.registry = r
+	r.configs = append(r.configs, s)
+
+	return s
+}
+
+// setMethods indexes s by its endpoint and methods. When several
+// configurations are added for the same route the first one is used.
+func (r *SecurityRegistry) setMethods(s *SecurityConfig, methods []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, method := range s.methods {
+		route := Route{Method: method, PathTemplate: s.endpoint}
+		if r.index[route] == s {
+			delete(r.index, route)
+		}
+	}
+
+	s.methods = methods
+
+	for _, method := range methods {
+		route := Route{Method: method, PathTemplate: s.endpoint}
+		if _, exists := r.index[route]; !exists {
+			r.index[route] = s
+		}
+	}
+}
+
+func (r *SecurityRegistry) lookup(req *http.Request) (_ SecurityConfig, found bool) {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return
+	}
+
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		return
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	config, found := r.index[Route{Method: req.Method, PathTemplate: pathTemplate}]
+	if !found {
+		// Configurations with an empty method match every method.
+		if config, found = r.index[Route{PathTemplate: pathTemplate}]; !found {
+			return
+		}
+	}
+
+	return *config, true
+}
+
+// UnsecuredRoutes walks router and returns the routes without a security
+// configuration in the registry. Routes not restricted to any methods are
+// reported with an empty Method. OPTIONS routes are not reported, since
+// CORS preflight requests don't carry credentials.
+func (r *SecurityRegistry) UnsecuredRoutes(router *mux.Router) ([]Route, error) {
+	routes, err := walkRoutes(router)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var unsecured []Route
+
+	for _, route := range routes {
+		if route.Method == http.MethodOptions {
+			continue
+		}
+
+		_, found := r.index[route]
+		if _, anyMethod := r.index[Route{PathTemplate: route.PathTemplate}]; !found && !anyMethod {
+			unsecured = append(unsecured, route)
+		}
+	}
+
+	return unsecured, nil
+}
+
+// UnroutedConfigs walks router and returns the configured endpoints and
+// methods that don't match any route, typically because of a typo in the
+// path template. Configurations without methods are reported with an empty
+// Method.
+func (r *SecurityRegistry) UnroutedConfigs(router *mux.Router) ([]Route, error) {
+	routes, err := walkRoutes(router)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := make(map[Route]bool, len(routes))
+	for _, route := range routes {
+		routed[route] = true
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var unrouted []Route
+
+	for _, config := range r.configs {
+		methods := config.methods
+		if len(methods) == 0 {
+			methods = []string{""}
+		}
+
+		for _, method := range methods {
+			route := Route{Method: method, PathTemplate: config.endpoint}
+
+			// Routes not restricted to any methods match every method.
+			if !routed[route] && !routed[Route{PathTemplate: config.endpoint}] {
+				unrouted = append(unrouted, route)
+			}
+		}
+	}
+
+	return unrouted, nil
+}
+
+// VerifyRouter returns an error listing the routes of router without a
+// security configuration and the configurations not matching any route.
+// Call it after all routes and configurations are added and refuse to start
+// when it fails, to fail closed on endpoints that were left unconfigured or
+// misconfigured by mistake.
+func (r *SecurityRegistry) VerifyRouter(router *mux.Router) error {
+	unsecured, err := r.UnsecuredRoutes(router)
+	if err != nil {
+		return err
+	}
+
+	unrouted, err := r.UnroutedConfigs(router)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+
+	if len(unsecured) > 0 {
+		problems = append(problems, "routes without security configuration: "+joinRoutes(unsecured))
+	}
+
+	if len(unrouted) > 0 {
+		problems = append(problems, "security configurations without route: "+joinRoutes(unrouted))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// walkRoutes returns the method and path template of each route of router
+// with a handler. Routes not restricted to any methods are returned with an
+// empty Method.
+func walkRoutes(router *mux.Router) ([]Route, error) {
+	var routes []Route
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return nil //nolint:nilerr // routes without a path can't be configured
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{""}
+		}
+
+		for _, method := range methods {
+			routes = append(routes, Route{Method: method, PathTemplate: pathTemplate})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk router: %w", err)
+	}
+
+	return routes, nil
+}
+
+func joinRoutes(routes []Route) string {
+	names := make([]string, 0, len(routes))
+	for _, route := range routes {
+		names = append(names, route.String())
+	}
+
+	return strings.Join(names, ", ")
+}
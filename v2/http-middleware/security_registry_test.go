@@ -0,0 +1,136 @@
+package httpmiddleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	"github.com/SKF/go-utility/v2/jwk"
+)
+
+func okHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func newRouter(registry *httpmiddleware.SecurityRegistry, provider jwk.KeyProvider) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id}", okHandler).Methods(http.MethodGet, http.MethodPut)
+	r.HandleFunc("/users/{id}", okHandler).Methods(http.MethodOptions)
+	r.HandleFunc("/health", okHandler)
+
+	r.Use(httpmiddleware.AuthenticateMiddlewareV3(
+		httpmiddleware.WithKeyProvider(provider),
+		httpmiddleware.WithSecurityRegistry(registry),
+	))
+
+	return r
+}
+
+func serve(r http.Handler, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set(httpmiddleware.HeaderAuthorization, token)
+	}
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	return resp.Code
+}
+
+func Test_SecurityRegistriesAreIndependent(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	secured := httpmiddleware.NewSecurityRegistry()
+	secured.HandleSecureEndpoint("/users/{id}").Methods(http.MethodGet, http.MethodPut).AccessToken()
+
+	open := httpmiddleware.NewSecurityRegistry()
+	open.HandleSecureEndpoint("/users/{id}").Methods(http.MethodPut).AccessToken()
+
+	securedRouter := newRouter(secured, provider)
+	openRouter := newRouter(open, provider)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(securedRouter, http.MethodGet, "/users/1", ""))
+	assert.Equal(t, http.StatusOK, serve(openRouter, http.MethodGet, "/users/1", ""))
+	assert.Equal(t, http.StatusUnauthorized, serve(openRouter, http.MethodPut, "/users/1", ""))
+
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+	assert.Equal(t, http.StatusOK, serve(securedRouter, http.MethodGet, "/users/1", token))
+}
+
+func Test_SecurityRegistryFirstConfigWins(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/users/{id}").Methods(http.MethodGet).AccessToken()
+	registry.HandleSecureEndpoint("/users/{id}").Methods(http.MethodGet)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(newRouter(registry, provider), http.MethodGet, "/users/1", ""))
+}
+
+func Test_SecurityRegistryUnsecuredRoutes(t *testing.T) {
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/users/{id}").Methods(http.MethodGet).AccessToken()
+
+	idp := authtest.NewServer(t)
+	r := newRouter(registry, jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0))
+
+	unsecured, err := registry.UnsecuredRoutes(r)
+	require.NoError(t, err)
+	assert.Equal(t, []httpmiddleware.Route{
+		{Method: http.MethodPut, PathTemplate: "/users/{id}"},
+		{Method: "", PathTemplate: "/health"},
+	}, unsecured)
+
+	err = registry.VerifyRouter(r)
+	require.ErrorContains(t, err, "PUT /users/{id}")
+
+	registry.HandleSecureEndpoint("/users/{id}").Methods(http.MethodPut).AccessToken()
+	registry.HandleSecureEndpoint("/health").Methods("")
+
+	require.NoError(t, registry.VerifyRouter(r))
+}
+
+func Test_StrictMode(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/users/{id}").Methods(http.MethodGet).AccessToken()
+	registry.HandleSecureEndpoint("/health").Methods("").Public()
+	// A typo in the path template leaves PUT /users/{id} unconfigured.
+	registry.HandleSecureEndpoint("/user/{id}").Methods(http.MethodPut).AccessToken()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id}", okHandler).Methods(http.MethodGet, http.MethodPut, http.MethodOptions)
+	r.HandleFunc("/health", okHandler)
+	r.Use(httpmiddleware.AuthenticateMiddlewareV3(
+		httpmiddleware.WithKeyProvider(provider),
+		httpmiddleware.WithSecurityRegistry(registry),
+		httpmiddleware.WithStrictMode(),
+	))
+
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/users/1", token))
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodGet, "/users/1", ""))
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodPut, "/users/1", token))
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodOptions, "/users/1", ""))
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodPost, "/health", ""))
+
+	unrouted, err := registry.UnroutedConfigs(r)
+	require.NoError(t, err)
+	assert.Equal(t, []httpmiddleware.Route{{Method: http.MethodPut, PathTemplate: "/user/{id}"}}, unrouted)
+
+	err = registry.VerifyRouter(r)
+	require.ErrorContains(t, err, "routes without security configuration: PUT /users/{id}")
+	require.ErrorContains(t, err, "security configurations without route: PUT /user/{id}")
+}
@@ -0,0 +1,492 @@
+package httpmiddleware_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SKF/proto/v2/common"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/SKF/go-utility/v2/auth/authtest"
+	"github.com/SKF/go-utility/v2/claimscontext"
+	httpmiddleware "github.com/SKF/go-utility/v2/http-middleware"
+	http_model "github.com/SKF/go-utility/v2/http-model"
+	"github.com/SKF/go-utility/v2/jwk"
+)
+
+type countingAuthorizer struct {
+	calls int
+}
+
+func (a *countingAuthorizer) IsAuthorizedWithContext(context.Context, string, string, *common.Origin) (bool, error) {
+	a.calls++
+	return true, nil
+}
+
+func Test_ClaimRequirements(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/roles").Methods(http.MethodGet).AccessToken().RequireRoles("admin", "support")
+	registry.HandleSecureEndpoint("/scopes").Methods(http.MethodGet).AccessToken().RequireScopes("users/read")
+	registry.HandleSecureEndpoint("/groups").Methods(http.MethodGet).AccessToken().RequireGroups("operators")
+	registry.HandleSecureEndpoint("/remote").Methods(http.MethodGet).AccessToken().
+		RequireGroups("operators").
+		Authorize("action", httpmiddleware.NilResourceFunc)
+
+	authorizer := &countingAuthorizer{}
+
+	r := mux.NewRouter()
+	for _, path := range []string{"/roles", "/scopes", "/groups", "/remote"} {
+		r.HandleFunc(path, okHandler).Methods(http.MethodGet)
+	}
+
+	r.Use(
+		httpmiddleware.AuthenticateMiddlewareV3(
+			httpmiddleware.WithKeyProvider(provider),
+			httpmiddleware.WithSecurityRegistry(registry),
+		),
+		httpmiddleware.AuthorizeMiddleware(authorizer, httpmiddleware.WithSecurityRegistry(registry)),
+	)
+
+	privileged := idp.AccessToken(authtest.User{
+		Username: "a.b@example.com",
+		UserID:   "user",
+		Roles:    "admin, support",
+		Scopes:   []string{"users/read", "users/write"},
+		Groups:   []string{"operators"},
+	})
+	unprivileged := idp.AccessToken(authtest.User{
+		Username: "c.d@example.com",
+		UserID:   "other",
+		Roles:    "admin",
+	})
+
+	for _, path := range []string{"/roles", "/scopes", "/groups", "/remote"} {
+		assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, path, privileged), path)
+		assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodGet, path, unprivileged), path)
+	}
+
+	// The authorizer is only called when the claim requirements are met.
+	assert.Equal(t, 1, authorizer.calls)
+}
+
+func Test_AuthenticateMiddlewareStoresClaims(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	registry.HandleSecureEndpoint("/me").Methods(http.MethodGet).AccessToken()
+
+	token := idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user", CompanyID: "company"})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimscontext.FromContext(r.Context())
+		rawToken, _ := claimscontext.RawTokenFromContext(r.Context())
+
+		if claims.EnlightCompanyID != "company" || rawToken != token {
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}).Methods(http.MethodGet)
+	r.Use(httpmiddleware.AuthenticateMiddlewareV3(
+		httpmiddleware.WithKeyProvider(provider),
+		httpmiddleware.WithSecurityRegistry(registry),
+	))
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/me", token))
+}
+
+// blockingAuthorizer allows the actions in allowed. Actions in blocked
+// block until their context is canceled.
+type blockingAuthorizer struct {
+	allowed map[string]bool
+	blocked map[string]bool
+	delay   time.Duration
+
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	canceled    int
+}
+
+func (a *blockingAuthorizer) IsAuthorizedWithContext(ctx context.Context, _, action string, _ *common.Origin) (bool, error) {
+	a.lock.Lock()
+	a.inFlight++
+	a.maxInFlight = max(a.maxInFlight, a.inFlight)
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		a.inFlight--
+		a.lock.Unlock()
+	}()
+
+	if a.blocked[action] {
+		<-ctx.Done()
+
+		a.lock.Lock()
+		a.canceled++
+		a.lock.Unlock()
+
+		return false, status.Error(codes.Canceled, ctx.Err().Error())
+	}
+
+	time.Sleep(a.delay)
+
+	return a.allowed[action], nil
+}
+
+func (a *blockingAuthorizer) stats() (maxInFlight, canceled int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.maxInFlight, a.canceled
+}
+
+func newAuthorizeRouter(t *testing.T, authorizer httpmiddleware.Authorizer, configure func(*httpmiddleware.SecurityConfig), opts ...httpmiddleware.SecurityOption) (*mux.Router, string) {
+	t.Helper()
+
+	return newAuthorizeRouterWithHandler(t, okHandler, authorizer, configure, opts...)
+}
+
+func newAuthorizeRouterWithHandler(t *testing.T, handler http.HandlerFunc, authorizer httpmiddleware.Authorizer, configure func(*httpmiddleware.SecurityConfig), opts ...httpmiddleware.SecurityOption) (*mux.Router, string) {
+	t.Helper()
+
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	registry := httpmiddleware.NewSecurityRegistry()
+	configure(registry.HandleSecureEndpoint("/assets/{id}").Methods(http.MethodGet).AccessToken())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/assets/{id}", handler).Methods(http.MethodGet)
+	r.Use(
+		httpmiddleware.AuthenticateMiddlewareV3(
+			httpmiddleware.WithKeyProvider(provider),
+			httpmiddleware.WithSecurityRegistry(registry),
+		),
+		httpmiddleware.AuthorizeMiddleware(authorizer, append(opts, httpmiddleware.WithSecurityRegistry(registry))...),
+	)
+
+	return r, idp.AccessToken(authtest.User{Username: "a.b@example.com", UserID: "user"})
+}
+
+func Test_AuthorizeAny(t *testing.T) {
+	anyOf := func(actions ...string) func(*httpmiddleware.SecurityConfig) {
+		return func(s *httpmiddleware.SecurityConfig) {
+			var authorizations []httpmiddleware.Authorization
+			for _, action := range actions {
+				authorizations = append(authorizations, httpmiddleware.Authorization{
+					Action:       action,
+					ResourceFunc: httpmiddleware.NilResourceFunc,
+				})
+			}
+
+			s.Authorize("asset/list", httpmiddleware.NilResourceFunc).AuthorizeAny(authorizations...)
+		}
+	}
+
+	authorizer := &blockingAuthorizer{allowed: map[string]bool{"asset/list": true, "company/admin": true}}
+
+	for _, test := range []struct {
+		name      string
+		configure func(*httpmiddleware.SecurityConfig)
+		status    int
+	}{
+		{name: "one allowed", configure: anyOf("asset/read", "company/admin"), status: http.StatusOK},
+		{name: "none allowed", configure: anyOf("asset/read", "asset/write"), status: http.StatusUnauthorized},
+		{name: "empty", configure: anyOf(), status: http.StatusUnauthorized},
+		{name: "required denied", configure: func(s *httpmiddleware.SecurityConfig) {
+			s.Authorize("asset/read", httpmiddleware.NilResourceFunc).
+				AuthorizeAny(httpmiddleware.Authorization{Action: "company/admin", ResourceFunc: httpmiddleware.NilResourceFunc})
+		}, status: http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, token := newAuthorizeRouter(t, authorizer, test.configure)
+			assert.Equal(t, test.status, serve(r, http.MethodGet, "/assets/1", token))
+		})
+	}
+}
+
+func Test_AuthorizeCancelsUndecidedChecks(t *testing.T) {
+	authorizer := &blockingAuthorizer{
+		allowed: map[string]bool{"company/admin": true},
+		blocked: map[string]bool{"asset/read": true, "asset/write": true},
+	}
+
+	r, token := newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		s.AuthorizeAny(
+			httpmiddleware.Authorization{Action: "asset/read", ResourceFunc: httpmiddleware.NilResourceFunc},
+			httpmiddleware.Authorization{Action: "company/admin", ResourceFunc: httpmiddleware.NilResourceFunc},
+		)
+	})
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/assets/1", token))
+
+	require.Eventually(t, func() bool {
+		_, canceled := authorizer.stats()
+		return canceled == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// A denied requirement decides the outcome while other checks are blocked.
+	authorizer.allowed = nil
+
+	r, token = newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		s.Authorize("asset/write", httpmiddleware.NilResourceFunc).
+			Authorize("company/admin", httpmiddleware.NilResourceFunc)
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodGet, "/assets/1", token))
+}
+
+func Test_AuthorizeBoundedConcurrency(t *testing.T) {
+	authorizer := &blockingAuthorizer{allowed: map[string]bool{}, delay: 10 * time.Millisecond}
+
+	r, token := newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		for i := range 8 {
+			action := fmt.Sprintf("action/%d", i)
+			authorizer.allowed[action] = true
+			s.Authorize(action, httpmiddleware.NilResourceFunc)
+		}
+	}, httpmiddleware.WithAuthorizationConcurrency(2))
+
+	assert.Equal(t, http.StatusOK, serve(r, http.MethodGet, "/assets/1", token))
+
+	maxInFlight, _ := authorizer.stats()
+	assert.Equal(t, 2, maxInFlight)
+}
+
+// cancelingAuthorizer cancels the request while checking an authorization.
+type cancelingAuthorizer struct {
+	cancel context.CancelFunc
+}
+
+func (a *cancelingAuthorizer) IsAuthorizedWithContext(ctx context.Context, _, _ string, _ *common.Origin) (bool, error) {
+	a.cancel()
+
+	return false, status.Error(codes.Canceled, ctx.Err().Error())
+}
+
+func Test_AuthorizeReturnsWhenCanceled(t *testing.T) {
+	authorizer := &cancelingAuthorizer{}
+
+	r, token := newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		s.AuthorizeAny(
+			httpmiddleware.Authorization{Action: "asset/read", ResourceFunc: httpmiddleware.NilResourceFunc},
+			httpmiddleware.Authorization{Action: "company/admin", ResourceFunc: httpmiddleware.NilResourceFunc},
+		)
+	}, httpmiddleware.WithAuthorizationConcurrency(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	authorizer.cancel = cancel
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/1", nil).WithContext(ctx)
+	req.Header.Set(httpmiddleware.HeaderAuthorization, token)
+
+	done := make(chan int)
+
+	go func() {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		done <- resp.Code
+	}()
+
+	select {
+	case code := <-done:
+		assert.Equal(t, http.StatusUnauthorized, code)
+	case <-time.After(time.Second):
+		t.Fatal("the request didn't return after being canceled")
+	}
+}
+
+func Test_AuthorizeDenialTakesPrecedenceOverLaterResourceErrors(t *testing.T) {
+	authorizer := &blockingAuthorizer{allowed: map[string]bool{"asset/read": true}}
+
+	r, token := newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		s.Authorize("asset/write", httpmiddleware.NilResourceFunc).
+			Authorize("asset/read", func(*http.Request) (*common.Origin, error) {
+				return nil, &http_model.HTTPError{Msg: "asset not found", StatusCode: http.StatusNotFound}
+			})
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodGet, "/assets/1", token))
+
+	// The error of a ResourceFunc is written once the earlier requirements
+	// are met.
+	authorizer.allowed["asset/write"] = true
+
+	assert.Equal(t, http.StatusNotFound, serve(r, http.MethodGet, "/assets/1", token))
+}
+
+func Test_AuthorizeAnyIsDecidedInOrder(t *testing.T) {
+	// The allowed authorization is answered after the ResourceFunc of the
+	// other one failed.
+	authorizer := &blockingAuthorizer{allowed: map[string]bool{"company/admin": true}, delay: 20 * time.Millisecond}
+
+	admin := httpmiddleware.Authorization{Action: "company/admin", ResourceFunc: httpmiddleware.NilResourceFunc}
+	read := httpmiddleware.Authorization{Action: "asset/read", ResourceFunc: func(*http.Request) (*common.Origin, error) {
+		return nil, &http_model.HTTPError{Msg: "asset not found", StatusCode: http.StatusNotFound}
+	}}
+
+	for _, test := range []struct {
+		name           string
+		authorizations []httpmiddleware.Authorization
+		status         int
+	}{
+		{name: "allowed first", authorizations: []httpmiddleware.Authorization{admin, read}, status: http.StatusOK},
+		{name: "failed first", authorizations: []httpmiddleware.Authorization{read, admin}, status: http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, token := newAuthorizeRouter(t, authorizer, func(s *httpmiddleware.SecurityConfig) {
+				s.AuthorizeAny(test.authorizations...)
+			})
+
+			for range 5 {
+				assert.Equal(t, test.status, serve(r, http.MethodGet, "/assets/1", token))
+			}
+		})
+	}
+}
+
+func Test_AuthorizeResourceFuncsReadingTheBody(t *testing.T) {
+	const payload = `{"id": "1"}`
+
+	authorizer := &blockingAuthorizer{allowed: map[string]bool{"company/admin": true}}
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil || string(body) != payload {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+
+	r, token := newAuthorizeRouterWithHandler(t, handler, authorizer, func(s *httpmiddleware.SecurityConfig) {
+		s.AuthorizeAny(
+			httpmiddleware.Authorization{Action: "company/admin", ResourceFunc: httpmiddleware.NilResourceFunc},
+			httpmiddleware.Authorization{Action: "asset/read", ResourceFunc: func(req *http.Request) (*common.Origin, error) {
+				body, err := io.ReadAll(req.Body)
+				req.Body = io.NopCloser(bytes.NewReader(body))
+
+				return &common.Origin{Id: string(body)}, err
+			}},
+		)
+	})
+
+	for range 10 {
+		req := httptest.NewRequest(http.MethodGet, "/assets/1", strings.NewReader(payload))
+		req.Header.Set(httpmiddleware.HeaderAuthorization, token)
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+}
+
+type forbiddenResponses struct{}
+
+func (forbiddenResponses) InternalErrorResponse() []byte  { return nil }
+func (forbiddenResponses) UnauthenticateResponse() []byte { return nil }
+func (forbiddenResponses) UnauthorizedResponse() []byte   { return []byte(`{"unauthorized": true}`) }
+func (forbiddenResponses) ForbiddenResponse() []byte      { return []byte(`{"forbidden": true}`) }
+
+func Test_RFC6750Responses(t *testing.T) {
+	idp := authtest.NewServer(t)
+	provider := jwk.NewProvider(idp.Client(), idp.KeySetsURL(), 0, 0)
+
+	newRouter := func(opts ...httpmiddleware.SecurityOption) *mux.Router {
+		registry := httpmiddleware.NewSecurityRegistry()
+		registry.HandleSecureEndpointCustomErrorResponse("/assets/{id}", forbiddenResponses{}).
+			Methods(http.MethodGet).
+			AccessToken().
+			RequireRoles("admin")
+
+		opts = append(opts, httpmiddleware.WithKeyProvider(provider), httpmiddleware.WithSecurityRegistry(registry))
+
+		r := mux.NewRouter()
+		r.HandleFunc("/assets/{id}", okHandler).Methods(http.MethodGet)
+		r.Use(
+			httpmiddleware.AuthenticateMiddlewareV3(opts...),
+			httpmiddleware.AuthorizeMiddleware(nil, opts...),
+		)
+
+		return r
+	}
+
+	user := authtest.User{Username: "a.b@example.com", UserID: "user"}
+
+	expiredClaims := idp.Claims(user, "access")
+	expiredClaims.ExpiresAt = gojwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	for _, test := range []struct {
+		name      string
+		token     string
+		status    int
+		challenge string
+		body      string
+	}{
+		{
+			name:      "missing token",
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer`,
+		},
+		{
+			name:      "expired token",
+			token:     idp.Sign(expiredClaims),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer error="invalid_token", error_description="The access token expired or is not yet valid"`,
+		},
+		{
+			name:      "invalid token",
+			token:     "invalid",
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer error="invalid_token", error_description="The access token is invalid"`,
+		},
+		{
+			name:      "not authorized",
+			token:     idp.AccessToken(user),
+			status:    http.StatusForbidden,
+			challenge: `Bearer error="insufficient_scope"`,
+			body:      `{"forbidden": true}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/assets/1", nil)
+			if test.token != "" {
+				req.Header.Set(httpmiddleware.HeaderAuthorization, test.token)
+			}
+
+			resp := httptest.NewRecorder()
+			newRouter(httpmiddleware.WithRFC6750()).ServeHTTP(resp, req)
+
+			assert.Equal(t, test.status, resp.Code)
+			assert.Equal(t, test.challenge, resp.Header().Get("WWW-Authenticate"))
+
+			if test.body != "" {
+				assert.Equal(t, test.body, resp.Body.String())
+			}
+
+			// Without the option the responses are unchanged.
+			resp = httptest.NewRecorder()
+			newRouter().ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.Empty(t, resp.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
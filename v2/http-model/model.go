@@ -8,9 +8,20 @@ import (
 
 const (
 	HeaderAuthorization           = "Authorization"
+	HeaderWWWAuthenticate         = "WWW-Authenticate"
 	HeaderContentType             = "Content-Type"
 	HeaderClientID                = "X-Client-ID"
 	HeaderCacheControl            = "Cache-Control"
+	HeaderRetryAfter              = "Retry-After"
+	HeaderRateLimitLimit          = "RateLimit-Limit"
+	HeaderRateLimitRemaining      = "RateLimit-Remaining"
+	HeaderRateLimitReset          = "RateLimit-Reset"
+	HeaderOrigin                  = "Origin"
+	HeaderVary                    = "Vary"
+	HeaderAcceptEncoding          = "Accept-Encoding"
+	HeaderContentEncoding         = "Content-Encoding"
+	HeaderContentLength           = "Content-Length"
+	HeaderContentRange            = "Content-Range"
 	HeaderDataDogTraceID          = trace.DatadogTraceIDHeader
 	HeaderDataDogParentID         = trace.DatadogParentIDHeader
 	HeaderDataDogSampled          = trace.DatadogSampledHeader
@@ -20,6 +31,15 @@ const (
 	HeaderB3SpanID                = trace.B3SpanIDHeader
 	HeaderB3Sampled               = trace.B3SampledHeader
 
+	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HeaderAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
+	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
+	HeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+
 	CacheControlNoCache = "no-cache"
 	MimeJSON            = "application/json"
 	MimeParameterUTF8   = "charset=utf-8"
@@ -53,8 +73,11 @@ func (e *HTTPError) Message() []byte {
 var ErrResponseUnsupportedMediaType = []byte(`{"error": {"message": "unsupported media type"}}`)
 var ErrResponseInternalServerError = []byte(`{"error": {"message": "internal server error"}}`)
 var ErrResponseBadRequest = []byte(`{"error": {"message": "bad request"}}`)
+var ErrResponseRequestEntityTooLarge = []byte(`{"error": {"message": "request entity too large"}}`)
 var ErrResponseTooManyRequests = []byte(`{"error": {"message": "Too many requests"}}`)
+var ErrResponseServiceUnavailable = []byte(`{"error": {"message": "service unavailable"}}`)
 var ErrResponseUnauthorized = []byte(`{"error": {"message": "unauthorized"}}`)
+var ErrResponseForbidden = []byte(`{"error": {"message": "forbidden"}}`)
 var ErrResponseNotFound = []byte(`{"error": {"message": "not found"}}`)
 var ErrResponseMethodNotAllowed = []byte(`{"error": {"message": "method not allowed"}}`)
 
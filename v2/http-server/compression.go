@@ -0,0 +1,161 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	http_model "github.com/SKF/go-utility/v2/http-model"
+)
+
+// Content codings supported for responses.
+const (
+	EncodingBrotli   = "br"
+	EncodingZstd     = "zstd"
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingIdentity = "identity"
+)
+
+// CompressionMinBodySize is the size under which bodies aren't compressed,
+// as they will be transmitted as a full packet anyway.
+const CompressionMinBodySize = 1400
+
+// preferredEncodings are the supported encodings, in the order they are
+// used when the client accepts several with the same q-value.
+var preferredEncodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip, EncodingDeflate}
+
+// encoder is a compressing writer that can be reused.
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingBrotli: {New: func() any { return brotli.NewWriter(nil) }},
+	EncodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)) //nolint: errcheck
+		return enc
+	}},
+	EncodingGzip:    {New: func() any { return gzip.NewWriter(nil) }},
+	EncodingDeflate: {New: func() any { return zlib.NewWriter(nil) }},
+}
+
+// NegotiateEncoding returns the encoding to use for a response, given the
+// Accept-Encoding header of the request. The supported encoding with the
+// highest q-value is used, and EncodingIdentity when none is accepted.
+func NegotiateEncoding(acceptEncoding string) string {
+	qValues := map[string]float64{}
+
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		qValues[name] = parseQValue(params)
+	}
+
+	best, bestQ := EncodingIdentity, 0.0
+
+	for _, encoding := range preferredEncodings {
+		q, found := qValues[encoding]
+		if !found {
+			q = qValues["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// parseQValue returns the q-value of the parameters of a coding, 1 when it
+// is missing and 0 when it's invalid.
+func parseQValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+
+		return q
+	}
+
+	return 1
+}
+
+// NewCompressWriter returns a writer compressing to w using encoding, which
+// must be one of the supported encodings except EncodingIdentity. The
+// encoders are pooled, closing the writer flushes the compressed data and
+// returns the encoder to the pool. It doesn't close w.
+func NewCompressWriter(w io.Writer, encoding string) (io.WriteCloser, error) {
+	pool, found := encoderPools[encoding]
+	if !found {
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	enc := pool.Get().(encoder)
+	enc.Reset(w)
+
+	return &compressWriter{encoder: enc, pool: pool}, nil
+}
+
+type compressWriter struct {
+	encoder
+	pool *sync.Pool
+}
+
+// Flush flushes the compressed data to the underlying writer.
+func (w *compressWriter) Flush() error {
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+func (w *compressWriter) Close() error {
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+
+	// Release the underlying writer before pooling the encoder.
+	w.encoder.Reset(io.Discard)
+	w.pool.Put(w.encoder)
+	w.encoder = nil
+
+	return err
+}
+
+// AddVary adds value to the Vary header of h, unless it's already there.
+func AddVary(h http.Header, value string) {
+	for _, vary := range h.Values(http_model.HeaderVary) {
+		for _, existing := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), value) {
+				return
+			}
+		}
+	}
+
+	h.Add(http_model.HeaderVary, value)
+}
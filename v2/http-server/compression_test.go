@@ -0,0 +1,102 @@
+package httpserver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	http_server "github.com/SKF/go-utility/v2/http-server"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for acceptEncoding, expected := range map[string]string{
+		"":                             http_server.EncodingIdentity,
+		"gzip":                         http_server.EncodingGzip,
+		"gzip, deflate, br, zstd":      http_server.EncodingBrotli,
+		"gzip;q=1.0, br;q=0.5":         http_server.EncodingGzip,
+		"GZIP;Q=0.8, deflate;q=0.9":    http_server.EncodingDeflate,
+		"br;q=0, *":                    http_server.EncodingZstd,
+		"*;q=0":                        http_server.EncodingIdentity,
+		"gzip;q=0":                     http_server.EncodingIdentity,
+		"gzip;q=invalid, deflate;q=.1": http_server.EncodingDeflate,
+		"compress, identity":           http_server.EncodingIdentity,
+		"nogzip":                       http_server.EncodingIdentity,
+	} {
+		assert.Equal(t, expected, http_server.NegotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func decompress(t *testing.T, encoding string, body io.Reader) []byte {
+	t.Helper()
+
+	var (
+		r   io.Reader
+		err error
+	)
+
+	switch encoding {
+	case http_server.EncodingBrotli:
+		r = brotli.NewReader(body)
+	case http_server.EncodingZstd:
+		var dec *zstd.Decoder
+		dec, err = zstd.NewReader(body)
+		r = dec
+	case http_server.EncodingGzip:
+		r, err = gzip.NewReader(body)
+	case http_server.EncodingDeflate:
+		r, err = zlib.NewReader(body)
+	default:
+		r = body
+	}
+
+	require.NoError(t, err)
+
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return decompressed
+}
+
+func TestWriteJSONResponseEncodings(t *testing.T) {
+	large := []byte(`{"data": "` + string(bytes.Repeat([]byte("a"), 2*http_server.CompressionMinBodySize)) + `"}`)
+
+	for _, encoding := range []string{
+		http_server.EncodingBrotli,
+		http_server.EncodingZstd,
+		http_server.EncodingGzip,
+		http_server.EncodingDeflate,
+	} {
+		// Twice to reuse the pooled encoders.
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+
+			resp := httptest.NewRecorder()
+			http_server.WriteJSONResponse(context.Background(), resp, req, http.StatusOK, large)
+
+			assert.Equal(t, encoding, resp.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"))
+			assert.Equal(t, large, decompress(t, encoding, resp.Body), encoding)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	http_server.WriteJSONResponse(context.Background(), resp, req, http.StatusOK, []byte(`{}`))
+
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"))
+	assert.Equal(t, `{}`, resp.Body.String())
+}
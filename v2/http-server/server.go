@@ -1,13 +1,11 @@
 package httpserver
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 
 	datadog "github.com/DataDog/opencensus-go-exporter-datadog"
 	"go.opencensus.io/plugin/ochttp"
@@ -84,21 +82,30 @@ func MarshalAndWriteJSONResponse(ctx context.Context, w http.ResponseWriter, r *
 	return response
 }
 
-// Don't gzip body if smaller than one packet, as it will be transmitted as a full packet anyway.
-const gzipMinBodySize = 1400
-
+// WriteJSONResponse writes body with the status code. The body is
+// compressed with the encoding negotiated from the Accept-Encoding header of
+// r, unless it's smaller than CompressionMinBodySize.
 func WriteJSONResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, code int, body []byte) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(http_model.HeaderContentType, http_model.MimeJSON)
+
+	encoding := EncodingIdentity
+
+	if r != nil {
+		AddVary(w.Header(), http_model.HeaderAcceptEncoding)
+
+		if len(body) > CompressionMinBodySize {
+			encoding = NegotiateEncoding(r.Header.Get(http_model.HeaderAcceptEncoding))
+		}
+	}
 
 	var err error
 
-	if r != nil && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && len(body) > gzipMinBodySize {
-		w.Header().Set("Content-Encoding", "gzip")
+	if encoding != EncodingIdentity {
+		w.Header().Set(http_model.HeaderContentEncoding, encoding)
+		w.Header().Del(http_model.HeaderContentLength)
 		w.WriteHeader(code)
-		gz := gzip.NewWriter(w)
 
-		defer gz.Close()
-		_, err = gz.Write(body)
+		err = writeCompressed(w, encoding, body)
 	} else {
 		w.WriteHeader(code)
 		_, err = w.Write(body)
@@ -111,6 +118,20 @@ func WriteJSONResponse(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func writeCompressed(w io.Writer, encoding string, body []byte) error {
+	cw, err := NewCompressWriter(w, encoding)
+	if err != nil {
+		return err
+	}
+
+	if _, err = cw.Write(body); err != nil {
+		cw.Close() //nolint: errcheck
+		return err
+	}
+
+	return cw.Close()
+}
+
 func StatusNotFoundHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		code := http.StatusNotFound
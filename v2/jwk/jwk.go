@@ -1,11 +1,17 @@
 package jwk
 
 import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
 	"net/http"
 	"sync"
@@ -32,10 +38,8 @@ var getKeySetsLock = &sync.Mutex{}
 type JWKeySets []JWKeySet
 
 func (kss JWKeySets) LookupKeyID(keyID string) (JWKeySet, error) {
-	for _, ks := range kss {
-		if ks.KeyID == keyID && ks.Use == "sig" {
-			return ks, nil
-		}
+	if ks, found := kss.find(keyID); found {
+		return ks, nil
 	}
 
 	if err := RefreshKeySets(); err != nil {
@@ -48,13 +52,21 @@ func (kss JWKeySets) LookupKeyID(keyID string) (JWKeySet, error) {
 	// just updates the global variable, which has no effect on the struct this
 	// function operates on. The previous version would loop over "kss" again
 	// here, which won't make a difference.
-	for _, ks := range keySets {
+	if ks, found := keySets.find(keyID); found {
+		return ks, nil
+	}
+
+	return JWKeySet{}, fmt.Errorf("unable to find public key")
+}
+
+func (kss JWKeySets) find(keyID string) (JWKeySet, bool) {
+	for _, ks := range kss {
 		if ks.KeyID == keyID && ks.Use == "sig" {
-			return ks, nil
+			return ks, true
 		}
 	}
 
-	return JWKeySet{}, fmt.Errorf("unable to find public key")
+	return JWKeySet{}, false
 }
 
 type JWKeySet struct {
@@ -64,11 +76,45 @@ type JWKeySet struct {
 	KeyType   string `json:"kty"`
 	Mod       string `json:"n"`
 	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
 }
 
+// Key types as defined in RFC 7518 and RFC 8037.
+const (
+	KeyTypeRSA = "RSA"
+	KeyTypeEC  = "EC"
+	KeyTypeOKP = "OKP"
+)
+
+// Curves as defined in RFC 7518 and RFC 8037.
+const (
+	CurveP256    = "P-256"
+	CurveP384    = "P-384"
+	CurveP521    = "P-521"
+	CurveEd25519 = "Ed25519"
+)
+
 const smallestExpLengthInBytes = 4
 
-func (ks JWKeySet) GetPublicKey() (*rsa.PublicKey, error) {
+// GetPublicKey returns an *rsa.PublicKey, *ecdsa.PublicKey or
+// ed25519.PublicKey depending on the key type.
+func (ks JWKeySet) GetPublicKey() (crypto.PublicKey, error) {
+	switch ks.KeyType {
+	case KeyTypeRSA, "":
+		// Key sets without a key type have always been treated as RSA keys.
+		return ks.getRSAPublicKey()
+	case KeyTypeEC:
+		return ks.getECDSAPublicKey()
+	case KeyTypeOKP:
+		return ks.getEd25519PublicKey()
+	default:
+		return nil, fmt.Errorf("unsupported key type `%s`", ks.KeyType)
+	}
+}
+
+func (ks JWKeySet) getRSAPublicKey() (*rsa.PublicKey, error) {
 	decodedE, err := base64.RawURLEncoding.DecodeString(ks.Exp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode key set `exp`: %w", err)
@@ -95,6 +141,108 @@ func (ks JWKeySet) GetPublicKey() (*rsa.PublicKey, error) {
 	return pubKey, nil
 }
 
+var ellipticCurves = map[string]struct {
+	curve elliptic.Curve
+	ecdh  ecdh.Curve
+}{
+	CurveP256: {elliptic.P256(), ecdh.P256()},
+	CurveP384: {elliptic.P384(), ecdh.P384()},
+	CurveP521: {elliptic.P521(), ecdh.P521()},
+}
+
+func (ks JWKeySet) getECDSAPublicKey() (*ecdsa.PublicKey, error) {
+	curve, found := ellipticCurves[ks.Curve]
+	if !found {
+		return nil, fmt.Errorf("unsupported elliptic curve `%s`", ks.Curve)
+	}
+
+	size := (curve.curve.Params().BitSize + 7) / 8 //nolint:mnd
+
+	decodedX, err := base64.RawURLEncoding.DecodeString(ks.X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key set `x`: %w", err)
+	}
+
+	decodedY, err := base64.RawURLEncoding.DecodeString(ks.Y)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key set `y`: %w", err)
+	}
+
+	if len(decodedX) != size || len(decodedY) != size {
+		return nil, fmt.Errorf("invalid coordinate length for curve `%s`", ks.Curve)
+	}
+
+	// Let crypto/ecdh reject points that aren't on the curve.
+	uncompressed := append([]byte{4}, append(decodedX, decodedY...)...) //nolint:mnd
+	if _, err = curve.ecdh.NewPublicKey(uncompressed); err != nil {
+		return nil, fmt.Errorf("invalid public key for curve `%s`: %w", ks.Curve, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve.curve,
+		X:     new(big.Int).SetBytes(decodedX),
+		Y:     new(big.Int).SetBytes(decodedY),
+	}, nil
+}
+
+func (ks JWKeySet) getEd25519PublicKey() (ed25519.PublicKey, error) {
+	if ks.Curve != CurveEd25519 {
+		return nil, fmt.Errorf("unsupported octet key pair curve `%s`", ks.Curve)
+	}
+
+	decodedX, err := base64.RawURLEncoding.DecodeString(ks.X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key set `x`: %w", err)
+	}
+
+	if len(decodedX) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length for curve `%s`", ks.Curve)
+	}
+
+	return ed25519.PublicKey(decodedX), nil
+}
+
+var algorithmsByKeyType = map[string]map[string]bool{
+	KeyTypeRSA: {
+		"RS256": true, "RS384": true, "RS512": true,
+		"PS256": true, "PS384": true, "PS512": true,
+	},
+	KeyTypeEC:  {"ES256": true, "ES384": true, "ES512": true},
+	KeyTypeOKP: {"EdDSA": true},
+}
+
+var algorithmByCurve = map[string]string{
+	CurveP256:    "ES256",
+	CurveP384:    "ES384",
+	CurveP521:    "ES512",
+	CurveEd25519: "EdDSA",
+}
+
+// ValidateAlgorithm checks that a token signed with alg may be verified with
+// the key. The algorithm has to match the `alg` of the key, if set, and be
+// usable with the key type and curve. This prevents algorithm confusion,
+// e.g. a token claiming to be signed with a symmetric algorithm.
+func (ks JWKeySet) ValidateAlgorithm(alg string) error {
+	if ks.Algorithm != "" && ks.Algorithm != alg {
+		return fmt.Errorf("token algorithm `%s` doesn't match key algorithm `%s`", alg, ks.Algorithm)
+	}
+
+	keyType := ks.KeyType
+	if keyType == "" {
+		keyType = KeyTypeRSA
+	}
+
+	if !algorithmsByKeyType[keyType][alg] {
+		return fmt.Errorf("token algorithm `%s` can't be used with key type `%s`", alg, keyType)
+	}
+
+	if keyType != KeyTypeRSA && algorithmByCurve[ks.Curve] != alg {
+		return fmt.Errorf("token algorithm `%s` can't be used with curve `%s`", alg, ks.Curve)
+	}
+
+	return nil
+}
+
 func GetKeySets() (JWKeySets, error) {
 	getKeySetsLock.Lock()
 	defer getKeySetsLock.Unlock()
@@ -121,31 +269,40 @@ func RefreshKeySets() error {
 
 	defer resp.Body.Close()
 
-	var data map[string]JWKeySets
-
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("received non 200 status code when fetching key sets, %d", resp.StatusCode)
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return fmt.Errorf("failed to unmarshal key sets: %w", err)
+	keys, err := decodeKeySets(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	keySets = keys
+
+	return nil
+}
+
+func decodeKeySets(body io.Reader) (JWKeySets, error) {
+	var data map[string]JWKeySets
+
+	if err := json.NewDecoder(body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key sets: %w", err)
 	}
 
 	if keys, present := data["Keys"]; present {
 		// keys from Cognito
-		keySets = keys
+		return keys, nil
 	} else if keys, present := data["keys"]; present {
 		// Keys compliant with RFC 7517: https://datatracker.ietf.org/doc/html/rfc7517#page-10
 		// "The JSON object MUST have a "keys" member, with its value being an array of JWKs."
-		keySets = keys
+		return keys, nil
 	} else if keys, present := data["data"]; present {
 		// keys from SSO-API
-		keySets = keys
-	} else {
-		return fmt.Errorf("failed to find key sets in response")
+		return keys, nil
 	}
 
-	return err
+	return nil, fmt.Errorf("failed to find key sets in response")
 }
 
 func getKeySetsURL() (string, error) {
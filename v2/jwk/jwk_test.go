@@ -0,0 +1,127 @@
+package jwk
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ecKeySet(t *testing.T, curve elliptic.Curve, crv string) (JWKeySet, *ecdsa.PublicKey) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+
+	size := (curve.Params().BitSize + 7) / 8
+
+	return JWKeySet{
+		KeyType: KeyTypeEC,
+		Curve:   crv,
+		X:       encode(key.X.FillBytes(make([]byte, size))),
+		Y:       encode(key.Y.FillBytes(make([]byte, size))),
+	}, &key.PublicKey
+}
+
+func Test_GetPublicKeyRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ks := JWKeySet{
+		KeyType: KeyTypeRSA,
+		Mod:     encode(key.N.Bytes()),
+		Exp:     encode(big.NewInt(int64(key.E)).Bytes()),
+	}
+
+	pub, err := ks.GetPublicKey()
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(pub))
+}
+
+func Test_GetPublicKeyEC(t *testing.T) {
+	for crv, curve := range map[string]elliptic.Curve{
+		CurveP256: elliptic.P256(),
+		CurveP384: elliptic.P384(),
+		CurveP521: elliptic.P521(),
+	} {
+		t.Run(crv, func(t *testing.T) {
+			ks, expected := ecKeySet(t, curve, crv)
+
+			pub, err := ks.GetPublicKey()
+			require.NoError(t, err)
+			assert.True(t, expected.Equal(pub))
+		})
+	}
+}
+
+func Test_GetPublicKeyECPointNotOnCurve(t *testing.T) {
+	ks, _ := ecKeySet(t, elliptic.P256(), CurveP256)
+	ks.Y = ks.X
+
+	_, err := ks.GetPublicKey()
+	require.Error(t, err)
+}
+
+func Test_GetPublicKeyECWrongCurve(t *testing.T) {
+	ks, _ := ecKeySet(t, elliptic.P256(), CurveP384)
+
+	_, err := ks.GetPublicKey()
+	require.Error(t, err)
+}
+
+func Test_GetPublicKeyEd25519(t *testing.T) {
+	expected, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	ks := JWKeySet{KeyType: KeyTypeOKP, Curve: CurveEd25519, X: encode(expected)}
+
+	pub, err := ks.GetPublicKey()
+	require.NoError(t, err)
+	assert.True(t, expected.Equal(pub))
+}
+
+func Test_GetPublicKeyUnsupportedKeyType(t *testing.T) {
+	_, err := JWKeySet{KeyType: "oct"}.GetPublicKey()
+	require.Error(t, err)
+}
+
+func Test_ValidateAlgorithm(t *testing.T) {
+	tests := []struct {
+		name  string
+		ks    JWKeySet
+		alg   string
+		valid bool
+	}{
+		{name: "RSA", ks: JWKeySet{KeyType: KeyTypeRSA, Algorithm: "RS256"}, alg: "RS256", valid: true},
+		{name: "RSA without alg", ks: JWKeySet{KeyType: KeyTypeRSA}, alg: "PS384", valid: true},
+		{name: "legacy without kty", ks: JWKeySet{}, alg: "RS256", valid: true},
+		{name: "alg mismatch", ks: JWKeySet{KeyType: KeyTypeRSA, Algorithm: "RS256"}, alg: "RS512", valid: false},
+		{name: "HMAC with RSA key", ks: JWKeySet{KeyType: KeyTypeRSA}, alg: "HS256", valid: false},
+		{name: "none", ks: JWKeySet{KeyType: KeyTypeRSA}, alg: "none", valid: false},
+		{name: "EC", ks: JWKeySet{KeyType: KeyTypeEC, Curve: CurveP384}, alg: "ES384", valid: true},
+		{name: "EC wrong curve", ks: JWKeySet{KeyType: KeyTypeEC, Curve: CurveP256}, alg: "ES384", valid: false},
+		{name: "RSA alg with EC key", ks: JWKeySet{KeyType: KeyTypeEC, Curve: CurveP256}, alg: "RS256", valid: false},
+		{name: "EdDSA", ks: JWKeySet{KeyType: KeyTypeOKP, Curve: CurveEd25519}, alg: "EdDSA", valid: true},
+		{name: "EdDSA with EC key", ks: JWKeySet{KeyType: KeyTypeEC, Curve: CurveP256}, alg: "EdDSA", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.ks.ValidateAlgorithm(test.alg)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package jwk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/SKF/go-utility/v2/log"
+)
+
+const (
+	DefaultRefreshInterval    = time.Hour
+	DefaultMinRefetchInterval = time.Minute
+)
+
+// KeyProvider looks up the JWK used to sign a token by its key ID.
+type KeyProvider interface {
+	LookupKeyID(keyID string) (JWKeySet, error)
+}
+
+var _ KeyProvider = (*Provider)(nil)
+
+// Provider fetches JWKs from a URL and keeps them up to date.
+//
+// Concurrent refreshes are coalesced into a single request. Refreshes caused
+// by an unknown key ID are limited to one per minimum refetch interval, so a
+// flood of tokens with forged key IDs can't be used to hammer the JWKS
+// endpoint. A Cache-Control max-age in the response shortens the time until
+// the next background refresh.
+type Provider struct {
+	client             *http.Client
+	url                string
+	refreshInterval    time.Duration
+	minRefetchInterval time.Duration
+
+	group singleflight.Group
+
+	lock        sync.RWMutex
+	keySets     JWKeySets
+	lastAttempt time.Time
+	expiresAt   time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewProvider creates a Provider fetching JWKs from url using client.
+// Non-positive intervals are replaced with DefaultRefreshInterval and
+// DefaultMinRefetchInterval.
+func NewProvider(client *http.Client, url string, refreshInterval, minRefetchInterval time.Duration) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+
+	if minRefetchInterval <= 0 {
+		minRefetchInterval = DefaultMinRefetchInterval
+	}
+
+	return &Provider{
+		client:             client,
+		url:                url,
+		refreshInterval:    refreshInterval,
+		minRefetchInterval: minRefetchInterval,
+		stop:               make(chan struct{}),
+	}
+}
+
+// Start fetches the key sets and keeps refreshing them in the background
+// until Stop is called.
+func (p *Provider) Start() {
+	go p.run()
+}
+
+// Stop ends the background refresh started by Start.
+func (p *Provider) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *Provider) run() {
+	for {
+		timer := time.NewTimer(p.untilNextRefresh())
+
+		select {
+		case <-p.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err := p.Refresh(context.Background()); err != nil {
+				log.WithError(err).
+					WithField("url", p.url).
+					Error("Couldn't refresh JWKeySets")
+			}
+		}
+	}
+}
+
+func (p *Provider) untilNextRefresh() time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	next := p.expiresAt
+	if earliest := p.lastAttempt.Add(p.minRefetchInterval); next.Before(earliest) {
+		next = earliest
+	}
+
+	return time.Until(next)
+}
+
+// KeySets returns the cached key sets, fetching them if none are cached yet.
+func (p *Provider) KeySets(ctx context.Context) (JWKeySets, error) {
+	p.lock.RLock()
+	keySets := p.keySets
+	p.lock.RUnlock()
+
+	if len(keySets) > 0 {
+		return keySets, nil
+	}
+
+	if err := p.refreshIfAllowed(ctx); err != nil {
+		return JWKeySets{}, err
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.keySets, nil
+}
+
+// LookupKeyID returns the signing key with the given key ID. If the key isn't
+// cached, the key sets are refetched unless that was attempted within the
+// minimum refetch interval.
+func (p *Provider) LookupKeyID(keyID string) (JWKeySet, error) {
+	ctx := context.Background()
+
+	keySets, err := p.KeySets(ctx)
+	if err != nil {
+		return JWKeySet{}, err
+	}
+
+	if ks, found := keySets.find(keyID); found {
+		return ks, nil
+	}
+
+	if err = p.refreshIfAllowed(ctx); err != nil {
+		return JWKeySet{}, err
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if ks, found := p.keySets.find(keyID); found {
+		return ks, nil
+	}
+
+	return JWKeySet{}, fmt.Errorf("unable to find public key")
+}
+
+func (p *Provider) refreshIfAllowed(ctx context.Context) error {
+	p.lock.RLock()
+	lastAttempt := p.lastAttempt
+	p.lock.RUnlock()
+
+	if since := time.Since(lastAttempt); since < p.minRefetchInterval {
+		return fmt.Errorf("key sets were refetched %s ago, next refetch allowed in %s", since, p.minRefetchInterval-since)
+	}
+
+	return p.Refresh(ctx)
+}
+
+// Refresh fetches the key sets. Concurrent calls share a single request.
+func (p *Provider) Refresh(ctx context.Context) error {
+	// The fetch is shared between callers, so it must not be cancelled
+	// just because the caller that happened to start it goes away.
+	ch := p.group.DoChan(p.url, func() (any, error) {
+		return nil, p.fetch(context.WithoutCancel(ctx))
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		return res.Err
+	}
+}
+
+func (p *Provider) fetch(ctx context.Context) error {
+	now := time.Now()
+
+	// The attempt is recorded once it has finished, so that callers arriving
+	// while it is in flight join it instead of being rate limited.
+	defer func() {
+		p.lock.Lock()
+		p.lastAttempt = now
+		p.lock.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch key sets: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non 200 status code when fetching key sets, %d", resp.StatusCode)
+	}
+
+	keySets, err := decodeKeySets(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	ttl := p.refreshInterval
+	if maxAge, found := parseMaxAge(resp.Header.Get("Cache-Control")); found && maxAge < ttl {
+		ttl = maxAge
+	}
+
+	p.lock.Lock()
+	p.keySets = keySets
+	p.expiresAt = now.Add(ttl)
+	p.lock.Unlock()
+
+	return nil
+}
+
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,178 @@
+package jwk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createKeySetServer(t *testing.T, keySets *atomic.Value, cacheControl string) (*httptest.Server, *int32) {
+	var requests int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]JWKeySets{
+			"keys": keySets.Load().(JWKeySets),
+		}))
+	}))
+	t.Cleanup(s.Close)
+
+	return s, &requests
+}
+
+func keySetsWith(keyIDs ...string) *atomic.Value {
+	var keySets JWKeySets
+	for _, keyID := range keyIDs {
+		keySets = append(keySets, JWKeySet{KeyID: keyID, Use: "sig"})
+	}
+
+	v := new(atomic.Value)
+	v.Store(keySets)
+
+	return v
+}
+
+func Test_ProviderLookupKeyID(t *testing.T) {
+	s, requests := createKeySetServer(t, keySetsWith("a", "b"), "")
+
+	p := NewProvider(s.Client(), s.URL, time.Hour, time.Minute)
+
+	key, err := p.LookupKeyID("b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", key.KeyID)
+
+	_, err = p.LookupKeyID("a")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func Test_ProviderRateLimitsUnknownKeyIDs(t *testing.T) {
+	s, requests := createKeySetServer(t, keySetsWith("a"), "")
+
+	p := NewProvider(s.Client(), s.URL, time.Hour, time.Minute)
+
+	for range 10 {
+		_, err := p.LookupKeyID("forged")
+		require.Error(t, err)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func Test_ProviderRefetchesUnknownKeyIDAfterMinInterval(t *testing.T) {
+	keySets := keySetsWith("a")
+	s, requests := createKeySetServer(t, keySets, "")
+
+	const minRefetchInterval = 10 * time.Millisecond
+
+	p := NewProvider(s.Client(), s.URL, time.Hour, minRefetchInterval)
+
+	_, err := p.LookupKeyID("a")
+	require.NoError(t, err)
+
+	keySets.Store(JWKeySets{{KeyID: "a", Use: "sig"}, {KeyID: "rotated", Use: "sig"}})
+	time.Sleep(minRefetchInterval)
+
+	key, err := p.LookupKeyID("rotated")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", key.KeyID)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
+
+func Test_ProviderCoalescesConcurrentRefreshes(t *testing.T) {
+	release := make(chan struct{})
+
+	var requests int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte(`{"keys": [{"kid": "a", "use": "sig"}]}`)) //nolint:errcheck
+	}))
+	defer s.Close()
+
+	p := NewProvider(s.Client(), s.URL, time.Hour, time.Minute)
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := p.LookupKeyID("a")
+			assert.NoError(t, err)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func Test_ProviderBackgroundRefreshHonoursMaxAge(t *testing.T) {
+	s, requests := createKeySetServer(t, keySetsWith("a"), "public, max-age=0")
+
+	p := NewProvider(s.Client(), s.URL, time.Hour, 10*time.Millisecond)
+	p.Start()
+	defer p.Stop()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(requests) >= 3
+	}, time.Second, time.Millisecond)
+}
+
+func Test_ProviderRefreshRespectsContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	p := NewProvider(&http.Client{Timeout: time.Second}, s.URL, time.Hour, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, p.Refresh(ctx), context.DeadlineExceeded)
+}
+
+func Test_ParseMaxAge(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected time.Duration
+		found    bool
+	}{
+		{header: "", found: false},
+		{header: "no-cache", found: false},
+		{header: "max-age=60", expected: time.Minute, found: true},
+		{header: "public, Max-Age=30, must-revalidate", expected: 30 * time.Second, found: true},
+		{header: `max-age="5"`, expected: 5 * time.Second, found: true},
+		{header: "max-age=-1", found: false},
+		{header: "max-age=abc", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			maxAge, found := parseMaxAge(test.header)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.expected, maxAge)
+		})
+	}
+}
@@ -22,3 +22,42 @@ func (e ErrNotValidNow) Is(target error) bool {
 
 	return errors.Is(e.underlyingErr, target)
 }
+
+// Check names the validation step a token failed in ErrInvalidToken.
+type Check string
+
+const (
+	CheckMalformed Check = "malformed"
+	CheckIssuer    Check = "issuer"
+	CheckSignature Check = "signature"
+	CheckTime      Check = "time"
+	CheckClaims    Check = "claims"
+	CheckTokenUse  Check = "token_use"
+	CheckAudience  Check = "audience"
+)
+
+// ErrInvalidToken is returned by Validator.Validate and describes which
+// check the token failed.
+type ErrInvalidToken struct {
+	Check         Check
+	underlyingErr error
+}
+
+func (e ErrInvalidToken) Error() string {
+	return "token failed " + string(e.Check) + " check: " + e.underlyingErr.Error()
+}
+
+func (e ErrInvalidToken) Unwrap() error {
+	return e.underlyingErr
+}
+
+func (e ErrInvalidToken) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrInvalidToken:
+		return t.Check == "" || t.Check == e.Check
+	case *ErrInvalidToken:
+		return t == nil || t.Check == "" || t.Check == e.Check
+	}
+
+	return errors.Is(e.underlyingErr, target)
+}
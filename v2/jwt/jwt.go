@@ -3,6 +3,8 @@ package jwt
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/SKF/go-utility/v2/jwk"
 
@@ -29,6 +31,14 @@ type CognitoClaims struct {
 	Username      string   `json:"username"`
 	TokenUse      string   `json:"token_use"`
 	CognitoGroups []string `json:"cognito:groups"`
+	ClientID      string   `json:"client_id,omitempty"`
+	// Scope is the space separated OAuth scopes of an access token.
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes returns the OAuth scopes of an access token.
+func (c CognitoClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
 }
 
 type EnlightClaims struct {
@@ -62,27 +72,87 @@ func (c Claims) Validate() error {
 	return nil
 }
 
-func keyFunc(token *jwt.Token) (any, error) {
-	keySets, err := jwk.GetKeySets()
-	if err != nil {
-		return Token{}, fmt.Errorf("failed to get key sets: %w", err)
+// validMethods are the asymmetric signing methods a JWKS can hold keys for.
+var validMethods = []string{
+	jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg(),
+	jwt.SigningMethodPS256.Alg(), jwt.SigningMethodPS384.Alg(), jwt.SigningMethodPS512.Alg(),
+	jwt.SigningMethodES256.Alg(), jwt.SigningMethodES384.Alg(), jwt.SigningMethodES512.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// ParseOption configures how Parse validates a token.
+type ParseOption func(*parseOptions)
+
+type parseOptions struct {
+	keyProvider jwk.KeyProvider
+	parserOpts  []jwt.ParserOption
+}
+
+// WithKeyProvider makes Parse look up signing keys using provider instead of
+// the key sets configured globally in the jwk package.
+func WithKeyProvider(provider jwk.KeyProvider) ParseOption {
+	return func(o *parseOptions) {
+		o.keyProvider = provider
 	}
+}
 
-	keyID, ok := token.Header["kid"].(string)
-	if !ok {
-		return nil, fmt.Errorf("expecting JWT header to have string `kid`")
+// WithLeeway allows the token to be used leeway before it becomes valid and
+// after it has expired, to account for clock skew.
+func WithLeeway(leeway time.Duration) ParseOption {
+	return func(o *parseOptions) {
+		o.parserOpts = append(o.parserOpts, jwt.WithLeeway(leeway))
 	}
+}
+
+// WithIssuer requires the `iss` claim of the token to be issuer.
+func WithIssuer(issuer string) ParseOption {
+	return func(o *parseOptions) {
+		o.parserOpts = append(o.parserOpts, jwt.WithIssuer(issuer))
+	}
+}
 
-	key, err := keySets.LookupKeyID(keyID)
+// packageKeySets looks up keys in the key sets configured globally in the
+// jwk package.
+type packageKeySets struct{}
+
+func (packageKeySets) LookupKeyID(keyID string) (jwk.JWKeySet, error) {
+	keySets, err := jwk.GetKeySets()
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup key id: %w", err)
+		return jwk.JWKeySet{}, fmt.Errorf("failed to get key sets: %w", err)
 	}
 
-	return key.GetPublicKey()
+	return keySets.LookupKeyID(keyID)
+}
+
+func keyFunc(provider jwk.KeyProvider) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		keyID, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("expecting JWT header to have string `kid`")
+		}
+
+		key, err := provider.LookupKeyID(keyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup key id: %w", err)
+		}
+
+		if err = key.ValidateAlgorithm(token.Method.Alg()); err != nil {
+			return nil, err
+		}
+
+		return key.GetPublicKey()
+	}
 }
 
-func Parse(jwtToken string) (Token, error) {
-	token, err := jwt.ParseWithClaims(jwtToken, &Claims{}, keyFunc)
+func Parse(jwtToken string, opts ...ParseOption) (Token, error) {
+	options := parseOptions{keyProvider: packageKeySets{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	parserOpts := append([]jwt.ParserOption{jwt.WithValidMethods(validMethods)}, options.parserOpts...)
+
+	token, err := jwt.ParseWithClaims(jwtToken, &Claims{}, keyFunc(options.keyProvider), parserOpts...)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
 			return Token{}, ErrNotValidNow{underlyingErr: err}
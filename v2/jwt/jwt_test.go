@@ -1,6 +1,9 @@
 package jwt_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
@@ -149,3 +152,117 @@ func Test_ValidInFuture(t *testing.T) {
 	_, err := jwt.Parse(string(signed))
 	require.Error(t, err)
 }
+
+func Test_ParseWithKeyProvider(t *testing.T) {
+	validKey, validSet := createKey(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		public, err := ljwk.PublicSetOf(validSet)
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(public))
+	}))
+	defer s.Close()
+
+	provider := jwk.NewProvider(s.Client(), s.URL, time.Hour, time.Minute)
+
+	signed := createSignedToken(t, validKey, map[string]any{
+		"token_use": jwt.TokenUseAccess,
+		"username":  "a.b@example.com",
+	})
+
+	parsedToken, err := jwt.Parse(string(signed), jwt.WithKeyProvider(provider))
+	require.NoError(t, err)
+	assert.Equal(t, "a.b@example.com", parsedToken.GetClaims().Username)
+
+	fakeKey, _ := createKey(t)
+	forged := createSignedToken(t, fakeKey, map[string]any{
+		"token_use": jwt.TokenUseAccess,
+		"username":  "a.b@example.com",
+	})
+
+	_, err = jwt.Parse(string(forged), jwt.WithKeyProvider(provider))
+	require.Error(t, err)
+}
+
+func createKeyFromRaw(t *testing.T, raw any, alg jwa.SignatureAlgorithm) (ljwk.Key, ljwk.Set) {
+	key, err := ljwk.FromRaw(raw)
+	require.NoError(t, err)
+
+	key.Set(ljwk.KeyIDKey, t.Name()) //nolint:errcheck
+	key.Set(ljwk.AlgorithmKey, alg)  //nolint:errcheck
+	key.Set(ljwk.KeyUsageKey, "sig") //nolint:errcheck
+
+	set := ljwk.NewSet()
+	require.NoError(t, set.AddKey(key))
+
+	return key, set
+}
+
+func createProvider(t *testing.T, set ljwk.Set) *jwk.Provider {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		public, err := ljwk.PublicSetOf(set)
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(public))
+	}))
+	t.Cleanup(s.Close)
+
+	return jwk.NewProvider(s.Client(), s.URL, time.Hour, time.Minute)
+}
+
+func createSignedTokenWithAlgorithm(t *testing.T, key ljwk.Key, alg jwa.SignatureAlgorithm) []byte {
+	token := ljwt.New()
+	token.Set("token_use", jwt.TokenUseAccess) //nolint:errcheck
+	token.Set("username", "a.b@example.com")   //nolint:errcheck
+
+	signed, err := ljwt.Sign(token, ljwt.WithKey(alg, key))
+	require.NoError(t, err)
+
+	return signed
+}
+
+func Test_ECAndOKPKeys(t *testing.T) {
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ec521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		raw  any
+		alg  jwa.SignatureAlgorithm
+	}{
+		{name: "ES256", raw: ec256, alg: jwa.ES256},
+		{name: "ES512", raw: ec521, alg: jwa.ES512},
+		{name: "EdDSA", raw: ed, alg: jwa.EdDSA},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, set := createKeyFromRaw(t, test.raw, test.alg)
+			provider := createProvider(t, set)
+
+			signed := createSignedTokenWithAlgorithm(t, key, test.alg)
+
+			parsedToken, err := jwt.Parse(string(signed), jwt.WithKeyProvider(provider))
+			require.NoError(t, err)
+			assert.Equal(t, "a.b@example.com", parsedToken.GetClaims().Username)
+		})
+	}
+}
+
+func Test_AlgorithmMustMatchKey(t *testing.T) {
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, set := createKeyFromRaw(t, raw, jwa.RS512)
+	provider := createProvider(t, set)
+
+	signed := createSignedTokenWithAlgorithm(t, key, jwa.RS256)
+
+	_, err = jwt.Parse(string(signed), jwt.WithKeyProvider(provider))
+	require.Error(t, err)
+}
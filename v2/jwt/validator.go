@@ -0,0 +1,146 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SKF/go-utility/v2/jwk"
+)
+
+// Issuer describes a trusted token issuer, e.g. a Cognito user pool or the
+// SSO API of a stage.
+type Issuer struct {
+	// Issuer is matched exactly against the `iss` claim.
+	Issuer string
+	// Keys provides the keys the issuer signs its tokens with.
+	Keys jwk.KeyProvider
+	// Audiences and ClientIDs list the accepted `aud` and `client_id`
+	// claims. If any is set, the token must match at least one of them.
+	// Cognito sets `aud` in ID tokens and `client_id` in access tokens.
+	Audiences []string
+	ClientIDs []string
+	// TokenUses lists the accepted `token_use` claims. Defaults to both
+	// TokenUseAccess and TokenUseID.
+	TokenUses []string
+	// Leeway is the clock skew allowed when checking `exp`, `nbf` and `iat`.
+	Leeway time.Duration
+}
+
+// Validator validates tokens from a set of trusted issuers.
+type Validator struct {
+	issuers map[string]Issuer
+}
+
+// NewValidator creates a Validator trusting the given issuers. Every issuer
+// must have an Issuer and Keys, and the issuers must be unique.
+func NewValidator(issuers ...Issuer) (*Validator, error) {
+	v := &Validator{issuers: make(map[string]Issuer, len(issuers))}
+
+	for i, issuer := range issuers {
+		if issuer.Issuer == "" {
+			return nil, fmt.Errorf("issuer %d has no Issuer", i)
+		}
+
+		if issuer.Keys == nil {
+			return nil, fmt.Errorf("issuer `%s` has no Keys", issuer.Issuer)
+		}
+
+		if _, found := v.issuers[issuer.Issuer]; found {
+			return nil, fmt.Errorf("issuer `%s` is configured twice", issuer.Issuer)
+		}
+
+		v.issuers[issuer.Issuer] = issuer
+	}
+
+	return v, nil
+}
+
+// Validate parses the token and checks its issuer, signature, time, token use
+// and audience. If the token is invalid, an ErrInvalidToken describing the
+// failed check is returned.
+func (v *Validator) Validate(jwtToken string) (Token, error) {
+	var unverified jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(jwtToken, &unverified); err != nil {
+		return Token{}, ErrInvalidToken{Check: CheckMalformed, underlyingErr: err}
+	}
+
+	issuer, found := v.issuers[unverified.Issuer]
+	if !found {
+		return Token{}, ErrInvalidToken{
+			Check:         CheckIssuer,
+			underlyingErr: fmt.Errorf("issuer `%s` is not trusted", unverified.Issuer),
+		}
+	}
+
+	token, err := Parse(
+		jwtToken,
+		WithKeyProvider(issuer.Keys),
+		WithIssuer(issuer.Issuer),
+		WithLeeway(issuer.Leeway),
+	)
+	if err != nil {
+		return Token{}, ErrInvalidToken{Check: parseErrorCheck(err), underlyingErr: err}
+	}
+
+	claims := token.GetClaims()
+
+	if err = issuer.checkTokenUse(claims); err != nil {
+		return Token{}, ErrInvalidToken{Check: CheckTokenUse, underlyingErr: err}
+	}
+
+	if err = issuer.checkAudience(claims); err != nil {
+		return Token{}, ErrInvalidToken{Check: CheckAudience, underlyingErr: err}
+	}
+
+	return token, nil
+}
+
+func parseErrorCheck(err error) Check {
+	switch {
+	case errors.Is(err, ErrNotValidNow{}), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return CheckTime
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return CheckMalformed
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return CheckIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return CheckClaims
+	default:
+		return CheckSignature
+	}
+}
+
+func (i Issuer) checkTokenUse(claims Claims) error {
+	tokenUses := i.TokenUses
+	if len(tokenUses) == 0 {
+		tokenUses = []string{TokenUseAccess, TokenUseID}
+	}
+
+	if !slices.Contains(tokenUses, claims.TokenUse) {
+		return fmt.Errorf("token use `%s` is not allowed", claims.TokenUse)
+	}
+
+	return nil
+}
+
+func (i Issuer) checkAudience(claims Claims) error {
+	if len(i.Audiences) == 0 && len(i.ClientIDs) == 0 {
+		return nil
+	}
+
+	for _, audience := range claims.Audience {
+		if slices.Contains(i.Audiences, audience) {
+			return nil
+		}
+	}
+
+	if claims.ClientID != "" && slices.Contains(i.ClientIDs, claims.ClientID) {
+		return nil
+	}
+
+	return fmt.Errorf("neither audience %v nor client ID `%s` is accepted", []string(claims.Audience), claims.ClientID)
+}
@@ -0,0 +1,190 @@
+package jwt_test
+
+import (
+	"testing"
+	"time"
+
+	ljwt "github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SKF/go-utility/v2/jwt"
+)
+
+const (
+	cognitoIssuer = "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_example"
+	ssoIssuer     = "https://sso-api.sandbox.users.enlight.skf.com"
+)
+
+func Test_Validator(t *testing.T) {
+	cognitoKey, cognitoSet := createKey(t)
+	ssoKey, ssoSet := createKey(t)
+
+	validator, err := jwt.NewValidator(
+		jwt.Issuer{
+			Issuer:    cognitoIssuer,
+			Keys:      createProvider(t, cognitoSet),
+			Audiences: []string{"web-client"},
+			ClientIDs: []string{"web-client", "service-client"},
+			Leeway:    time.Minute,
+		},
+		jwt.Issuer{
+			Issuer:    ssoIssuer,
+			Keys:      createProvider(t, ssoSet),
+			TokenUses: []string{jwt.TokenUseID},
+		},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		signed []byte
+		check  jwt.Check
+	}{
+		{
+			name: "cognito access token with client ID",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey: cognitoIssuer,
+				"token_use":    jwt.TokenUseAccess,
+				"username":     "a.b@example.com",
+				"client_id":    "service-client",
+			}),
+		},
+		{
+			name: "cognito ID token with audience",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey:   cognitoIssuer,
+				ljwt.AudienceKey: "web-client",
+				"token_use":      jwt.TokenUseID,
+				"enlightUserId":  "user",
+			}),
+		},
+		{
+			name: "expired within leeway",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey:     cognitoIssuer,
+				ljwt.ExpirationKey: time.Now().Add(-30 * time.Second),
+				"token_use":        jwt.TokenUseAccess,
+				"username":         "a.b@example.com",
+				"client_id":        "service-client",
+			}),
+		},
+		{
+			name: "sso ID token",
+			signed: createSignedToken(t, ssoKey, map[string]any{
+				ljwt.IssuerKey:  ssoIssuer,
+				"token_use":     jwt.TokenUseID,
+				"enlightUserId": "user",
+			}),
+		},
+		{
+			name: "untrusted issuer",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey: "https://evil.example.com",
+				"token_use":    jwt.TokenUseAccess,
+				"username":     "a.b@example.com",
+			}),
+			check: jwt.CheckIssuer,
+		},
+		{
+			name: "signed by other issuer",
+			signed: createSignedToken(t, ssoKey, map[string]any{
+				ljwt.IssuerKey: cognitoIssuer,
+				"token_use":    jwt.TokenUseAccess,
+				"username":     "a.b@example.com",
+				"client_id":    "service-client",
+			}),
+			check: jwt.CheckSignature,
+		},
+		{
+			name: "expired beyond leeway",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey:     cognitoIssuer,
+				ljwt.ExpirationKey: time.Now().Add(-time.Hour),
+				"token_use":        jwt.TokenUseAccess,
+				"username":         "a.b@example.com",
+				"client_id":        "service-client",
+			}),
+			check: jwt.CheckTime,
+		},
+		{
+			name: "wrong client ID",
+			signed: createSignedToken(t, cognitoKey, map[string]any{
+				ljwt.IssuerKey: cognitoIssuer,
+				"token_use":    jwt.TokenUseAccess,
+				"username":     "a.b@example.com",
+				"client_id":    "other-client",
+			}),
+			check: jwt.CheckAudience,
+		},
+		{
+			name: "token use not allowed",
+			signed: createSignedToken(t, ssoKey, map[string]any{
+				ljwt.IssuerKey: ssoIssuer,
+				"token_use":    jwt.TokenUseAccess,
+				"username":     "a.b@example.com",
+			}),
+			check: jwt.CheckTokenUse,
+		},
+		{
+			name: "missing username",
+			signed: createSignedToken(t, ssoKey, map[string]any{
+				ljwt.IssuerKey: ssoIssuer,
+				"token_use":    jwt.TokenUseAccess,
+			}),
+			check: jwt.CheckClaims,
+		},
+		{
+			name:   "malformed",
+			signed: []byte("not.a.token"),
+			check:  jwt.CheckMalformed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := validator.Validate(string(test.signed))
+			if test.check == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var invalid jwt.ErrInvalidToken
+
+			require.ErrorAs(t, err, &invalid)
+			assert.Equal(t, test.check, invalid.Check)
+			assert.ErrorIs(t, err, jwt.ErrInvalidToken{Check: test.check})
+		})
+	}
+}
+
+func Test_ValidatorExpiredIsNotValidNow(t *testing.T) {
+	key, set := createKey(t)
+
+	validator, err := jwt.NewValidator(jwt.Issuer{Issuer: ssoIssuer, Keys: createProvider(t, set)})
+	require.NoError(t, err)
+
+	signed := createSignedToken(t, key, map[string]any{
+		ljwt.IssuerKey:     ssoIssuer,
+		ljwt.ExpirationKey: time.Now().Add(-time.Hour),
+		"token_use":        jwt.TokenUseAccess,
+		"username":         "a.b@example.com",
+	})
+
+	_, err = validator.Validate(string(signed))
+	require.ErrorIs(t, err, jwt.ErrNotValidNow{})
+}
+
+func TestNewValidatorInvalidIssuers(t *testing.T) {
+	_, set := createKey(t)
+	provider := createProvider(t, set)
+
+	for name, issuers := range map[string][]jwt.Issuer{
+		"missing issuer": {{Keys: provider}},
+		"missing keys":   {{Issuer: ssoIssuer}},
+		"duplicate":      {{Issuer: ssoIssuer, Keys: provider}, {Issuer: ssoIssuer, Keys: provider}},
+	} {
+		_, err := jwt.NewValidator(issuers...)
+		require.Error(t, err, name)
+	}
+}
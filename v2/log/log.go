@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/SKF/go-utility/v2/env"
+	"github.com/SKF/go-utility/v2/requestid"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -152,6 +153,27 @@ func WithUserID(ctx context.Context) Logger {
 	return baseLogger.WithUserID(ctx)
 }
 
+// WithRequestID will add the request ID, chain and transaction ID from the
+// requestid package as log fields.
+func WithRequestID(ctx context.Context) Logger {
+	return baseLogger.WithFields(RequestIDFields(ctx))
+}
+
+// RequestIDFields returns the request ID, chain and transaction ID from the
+// requestid package as log fields, to add to any Logger with WithFields.
+func RequestIDFields(ctx context.Context) Fields {
+	request, ok := requestid.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return Fields{
+		zap.Any(requestid.RequestIDKey, request.ID),
+		zap.Any(requestid.RequestChainKey, request.Chain),
+		zap.Any(requestid.RequestTransactionIDKey, request.TransactionID),
+	}
+}
+
 func OnlyWithTracing(ctx context.Context) Logger {
 	return baseLogger.OnlyWithTracing(ctx)
 }
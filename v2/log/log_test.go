@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/SKF/go-utility/v2/log"
+	"github.com/SKF/go-utility/v2/requestid"
 )
 
 func TestLog(t *testing.T) {
@@ -31,6 +32,8 @@ func TestLog(t *testing.T) {
 	log.WithError(errors.New("A test error")).Error("A test error, should have stacktrace")
 	log.WithTracing(context.TODO()).Info("Actual tracing information would be empty")
 	log.OnlyWithTracing(context.TODO()).Info("Empty context should not log anything")
+	log.WithRequestID(requestid.ExtendContext(context.TODO(), "service")).Info("A msg with request ID")
+	log.WithError(errors.New("A test error")).WithFields(log.RequestIDFields(context.TODO())).Info("A msg without request ID")
 
 	assert.Panics(t, panicLog)
 }
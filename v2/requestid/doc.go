@@ -0,0 +1,12 @@
+// Package requestid keeps track of chained requests and calls within a
+// transaction, across both gRPC and HTTP services.
+//
+// A Request is stored in the context by the server interceptors and the
+// HTTP Middleware and is sent along with outgoing calls by the client
+// interceptors and the Transport. The ID is kept for the whole chain of
+// calls, every service appends its name to the Chain and gets a new
+// TransactionID.
+//
+// Use log.WithRequestID, or log.RequestIDFields with an existing logger, to
+// add the request fields to log entries.
+package requestid
@@ -0,0 +1,73 @@
+package requestid
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor returns a new unary server interceptor that adds
+// the Request ID Metadata to the call.
+func UnaryServerInterceptor(serviceName string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(incomingContext(ctx, serviceName), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor that adds
+// the Request ID Metadata to the call.
+func StreamServerInterceptor(serviceName string) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx := incomingContext(stream.Context(), serviceName)
+		return handler(srv, &serverStream{ServerStream: stream, ctx: newCtx})
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor that adds
+// the Request ID Metadata to the call.
+func UnaryClientInterceptor(serviceName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx, serviceName), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor that adds
+// the Request ID Metadata to the call.
+func StreamClientInterceptor(serviceName string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx, serviceName), desc, cc, method, opts...)
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func incomingContext(ctx context.Context, serviceName string) context.Context {
+	var parent Request
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		parent.ID = parseID(md.Get(RequestIDKey))
+		parent.Chain = md.Get(RequestChainKey)
+	}
+
+	return NewContext(ctx, Continue(parent, serviceName))
+}
+
+func outgoingContext(ctx context.Context, serviceName string) context.Context {
+	request := fromContextOrNew(ctx, serviceName)
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(RequestIDKey, request.ID.String())
+	md.Set(RequestChainKey, request.Chain...)
+	md.Set(RequestTransactionIDKey, request.TransactionID.String())
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
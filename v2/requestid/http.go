@@ -0,0 +1,73 @@
+package requestid
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Middleware returns a HTTP middleware that adds the Request ID Metadata
+// from the request headers to the request context. The request ID is also
+// written to the X-Request-ID response header.
+func Middleware(serviceName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := Request{
+				ID:    parseID(r.Header.Values(HeaderRequestID)),
+				Chain: parseChain(r.Header.Values(HeaderRequestChain)),
+			}
+
+			request := Continue(parent, serviceName)
+			w.Header().Set(HeaderRequestID, request.ID.String())
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), request)))
+		})
+	}
+}
+
+// Transport is a http.RoundTripper adding the Request ID Metadata from the
+// request context to the request headers. When the context doesn't hold a
+// Request a new chain is started at ServiceName.
+type Transport struct {
+	ServiceName string
+
+	// Base is the RoundTripper used to make the requests, if nil
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request := fromContextOrNew(req.Context(), t.ServiceName)
+
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderRequestID, request.ID.String())
+	req.Header.Set(HeaderRequestChain, strings.Join(request.Chain, ","))
+	req.Header.Set(HeaderTransactionID, request.TransactionID.String())
+
+	return t.base().RoundTrip(req)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+
+	return http.DefaultTransport
+}
+
+func parseChain(values []string) []string {
+	var chain []string
+
+	for _, value := range values {
+		for _, service := range strings.Split(value, ",") {
+			if service = strings.TrimSpace(service); service != "" {
+				chain = append(chain, service)
+			}
+		}
+	}
+
+	return chain
+}
@@ -0,0 +1,104 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+
+	"github.com/SKF/go-utility/v2/uuid"
+)
+
+// Keys used in gRPC metadata and log fields.
+const (
+	RequestIDKey            = "request.id"
+	RequestChainKey         = "request.chain"
+	RequestTransactionIDKey = "request.transaction.id"
+)
+
+// HTTP headers.
+const (
+	HeaderRequestID     = "X-Request-ID"
+	HeaderRequestChain  = "X-Request-Chain"
+	HeaderTransactionID = "X-Transaction-ID"
+)
+
+// Request is a data holder for the different Request ID Metadata
+type Request struct {
+	ID            uuid.UUID `json:"id"`
+	Chain         []string  `json:"chain"`
+	TransactionID uuid.UUID `json:"transactionId"`
+}
+
+type requestContextKey struct{}
+
+// FromContext extracts the Request from a context.
+func FromContext(ctx context.Context) (Request, bool) {
+	request, ok := ctx.Value(requestContextKey{}).(Request)
+	return request, ok
+}
+
+// NewContext adds the Request to a new context.
+func NewContext(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, request)
+}
+
+// New creates a Request starting a new chain at serviceName.
+func New(serviceName string) Request {
+	transactionID := uuid.New()
+
+	return Request{
+		ID:            transactionID,
+		Chain:         []string{serviceName},
+		TransactionID: transactionID,
+	}
+}
+
+// Continue creates a Request for serviceName handling a call made within
+// parent. The ID of the parent is kept if it's valid, serviceName is appended
+// to the chain and a new TransactionID is created.
+func Continue(parent Request, serviceName string) Request {
+	request := New(serviceName)
+
+	if parent.ID.IsValid() {
+		request.ID = parent.ID
+	}
+
+	request.Chain = append(append([]string{}, parent.Chain...), serviceName)
+
+	return request
+}
+
+// ExtendContext extends the context with a Request for serviceName. If the
+// context already holds a Request it's continued, otherwise a new chain is
+// started.
+func ExtendContext(ctx context.Context, serviceName string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	parent, _ := FromContext(ctx)
+
+	return NewContext(ctx, Continue(parent, serviceName))
+}
+
+// fromContextOrNew returns the Request in ctx, or a new Request for
+// serviceName when ctx doesn't hold one.
+func fromContextOrNew(ctx context.Context, serviceName string) Request {
+	if request, ok := FromContext(ctx); ok {
+		return request
+	}
+
+	return New(serviceName)
+}
+
+func parseID(values []string) uuid.UUID {
+	if len(values) == 0 {
+		return ""
+	}
+
+	id := uuid.UUID(strings.TrimSpace(values[0]))
+	if !id.IsValid() {
+		return ""
+	}
+
+	return id
+}
@@ -0,0 +1,130 @@
+package requestid_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/SKF/go-utility/v2/requestid"
+	"github.com/SKF/go-utility/v2/uuid"
+)
+
+func Test_ExtendContext(t *testing.T) {
+	ctx := requestid.ExtendContext(context.Background(), "first")
+
+	first, ok := requestid.FromContext(ctx)
+	require.True(t, ok)
+	assert.True(t, first.ID.IsValid())
+	assert.Equal(t, []string{"first"}, first.Chain)
+	assert.Equal(t, first.ID, first.TransactionID)
+
+	second, _ := requestid.FromContext(requestid.ExtendContext(ctx, "second"))
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, []string{"first", "second"}, second.Chain)
+	assert.NotEqual(t, first.TransactionID, second.TransactionID)
+}
+
+// Test_GRPCPropagation makes a call from a client with the client
+// interceptor to a server with the server interceptor.
+func Test_GRPCPropagation(t *testing.T) {
+	ctx := requestid.ExtendContext(context.Background(), "client")
+	client, _ := requestid.FromContext(ctx)
+
+	var outgoing metadata.MD
+
+	invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	err := requestid.UnaryClientInterceptor("client")(ctx, "/service/Method", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.Equal(t, []string{client.ID.String()}, outgoing.Get(requestid.RequestIDKey))
+	assert.Equal(t, []string{"client"}, outgoing.Get(requestid.RequestChainKey))
+
+	var server requestid.Request
+
+	handler := func(ctx context.Context, _ any) (any, error) {
+		server, _ = requestid.FromContext(ctx)
+		return nil, nil
+	}
+
+	incoming := metadata.NewIncomingContext(context.Background(), outgoing)
+	_, err = requestid.UnaryServerInterceptor("server")(incoming, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+
+	assert.Equal(t, client.ID, server.ID)
+	assert.Equal(t, []string{"client", "server"}, server.Chain)
+	assert.NotEqual(t, client.TransactionID, server.TransactionID)
+}
+
+func Test_GRPCClientWithoutRequest(t *testing.T) {
+	var outgoing metadata.MD
+
+	streamer := func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "other", "value")
+
+	_, err := requestid.StreamClientInterceptor("client")(ctx, nil, nil, "/service/Method", streamer)
+	require.NoError(t, err)
+
+	assert.True(t, uuid.IsValid(outgoing.Get(requestid.RequestIDKey)[0]))
+	assert.Equal(t, []string{"client"}, outgoing.Get(requestid.RequestChainKey))
+	assert.Equal(t, []string{"value"}, outgoing.Get("other"))
+}
+
+// Test_HTTPPropagation makes a request through the Transport to a server
+// using the Middleware.
+func Test_HTTPPropagation(t *testing.T) {
+	var server requestid.Request
+
+	handler := requestid.Middleware("server")(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		server, _ = requestid.FromContext(r.Context())
+	}))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	ctx := requestid.ExtendContext(context.Background(), "gateway")
+	ctx = requestid.ExtendContext(ctx, "client")
+	client, _ := requestid.FromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+
+	httpClient := &http.Client{Transport: &requestid.Transport{ServiceName: "client"}}
+
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Empty(t, req.Header.Get(requestid.HeaderRequestID), "the request must not be modified")
+	assert.Equal(t, client.ID.String(), resp.Header.Get(requestid.HeaderRequestID))
+	assert.Equal(t, client.ID, server.ID)
+	assert.Equal(t, []string{"gateway", "client", "server"}, server.Chain)
+}
+
+func Test_MiddlewareIgnoresInvalidID(t *testing.T) {
+	var server requestid.Request
+
+	handler := requestid.Middleware("server")(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		server, _ = requestid.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestid.HeaderRequestID, "invalid")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, server.ID.IsValid())
+	assert.Equal(t, []string{"server"}, server.Chain)
+}